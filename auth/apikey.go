@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"products/models"
+	"time"
+)
+
+const (
+	keyPrefix           = "sk_"
+	signingSecretPrefix = "ss_"
+	displayChars        = 8
+	// lastUsedPrecision bounds how often the last use of a key is written.
+	lastUsedPrecision = time.Minute
+)
+
+// SharedSecretSubject is the subject of callers authenticated with
+// API_SECRET_KEY or one of its previous keys.
+const SharedSecretSubject = "env:API_SECRET_KEY"
+
+var ErrInvalidKey = errors.New("invalid API key")
+
+// GenerateKey returns a new random API key and its hash.
+func GenerateKey() (string, string, error) {
+	key, err := randomSecret(keyPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	return key, HashKey(key), nil
+}
+
+// GenerateSigningSecret returns a new random secret to sign the requests made
+// with an API key.
+func GenerateSigningSecret() (string, error) {
+	return randomSecret(signingSecretPrefix)
+}
+
+func randomSecret(prefix string) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// HashKey is the SHA-256 hash API keys are stored and looked up by.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashesEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// DisplayPrefix is the part of a key shown in listings.
+func DisplayPrefix(key string) string {
+	return key[:min(len(key), len(keyPrefix)+displayChars)]
+}
+
+// Authenticate finds the caller presenting key. API_SECRET_KEY and the
+// previous keys still accepted after rotating it keep every scope so
+// deployments predating named keys keep working; other keys must be issued,
+// active API keys. Keys are compared by their hashes in constant time, so
+// response times do not reveal how much of a key was guessed.
+func Authenticate(db *gorm.DB, key string) (*Principal, error) {
+	if key == "" {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	hash := HashKey(key)
+
+	var matched *configuredKey
+	for _, configured := range configuredKeys() {
+		if hashesEqual(hash, HashKey(configured.Key)) && matched == nil {
+			matched = &configured
+		}
+	}
+	if matched != nil {
+		if matched.ExpiresAt != nil && !now.Before(*matched.ExpiresAt) {
+			return nil, ErrInvalidKey
+		}
+		return &Principal{
+			Subject:      SharedSecretSubject,
+			Name:         "API_SECRET_KEY",
+			Scopes:       Scopes,
+			Credential:   matched.Credential,
+			ExpiresAt:    matched.ExpiresAt,
+			DeprecatedAt: matched.DeprecatedAt,
+		}, nil
+	}
+
+	var apiKey models.APIKey
+	err := db.Where("key_hash = ?", hash).First(&apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !hashesEqual(hash, apiKey.KeyHash) || !apiKey.Active(now) {
+		return nil, ErrInvalidKey
+	}
+
+	recordUse(db, apiKey, now)
+	return apiKeyPrincipal(apiKey), nil
+}
+
+// recordUse sets when an API key was last used, at most once every
+// lastUsedPrecision.
+func recordUse(db *gorm.DB, apiKey models.APIKey, now time.Time) {
+	if apiKey.LastUsedAt != nil && now.Sub(*apiKey.LastUsedAt) <= lastUsedPrecision {
+		return
+	}
+	err := db.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).Update("last_used_at", now).Error
+	if err != nil {
+		log.Printf("Error recording use of API key %s: %s", apiKey.ID, err)
+	}
+}
+
+// apiKeyPrincipal is the caller authenticated with an issued API key.
+func apiKeyPrincipal(apiKey models.APIKey) *Principal {
+	subject := "api_key:" + apiKey.ID.String()
+	return &Principal{
+		Subject:      subject,
+		Name:         apiKey.Name,
+		Scopes:       apiKey.Scopes,
+		Credential:   subject,
+		ExpiresAt:    apiKey.ExpiresAt,
+		DeprecatedAt: apiKey.RotatedAt,
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonWebKey is the subset of RFC 7517 needed to verify RS256 and ES256
+// signatures.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a public key read from a JWKS file.
+type verificationKey struct {
+	Kid string
+	// Alg is the algorithm the key verifies, RS256 or ES256.
+	Alg string
+	Key any
+}
+
+// jwksFile caches the keys of the JWKS file at JWT_JWKS_FILE, reloading them
+// when the file changes so keys can be rotated without a restart.
+type jwksFile struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	keys    []verificationKey
+}
+
+var jwks jwksFile
+
+// Keys returns the keys of the JWKS file at path.
+func (f *jwksFile) Keys(path string) ([]verificationKey, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.path == path && f.modTime.Equal(info.ModTime()) {
+		return f.keys, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	f.path, f.modTime, f.keys = path, info.ModTime(), keys
+	return keys, nil
+}
+
+// parseJWKS reads the RSA and P-256 signing keys of a JWKS document. Other
+// keys are skipped.
+func parseJWKS(raw []byte) ([]verificationKey, error) {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+
+	var keys []verificationKey
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch {
+		case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == "RS256"):
+			n, errN := decodeBigInt(jwk.N)
+			e, errE := decodeBigInt(jwk.E)
+			if err := errors.Join(errN, errE); err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %q", jwk.Kid)
+			}
+			keys = append(keys, verificationKey{Kid: jwk.Kid, Alg: "RS256", Key: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case jwk.Kty == "EC" && jwk.Crv == "P-256" && (jwk.Alg == "" || jwk.Alg == "ES256"):
+			x, errX := decodeBigInt(jwk.X)
+			y, errY := decodeBigInt(jwk.Y)
+			if err := errors.Join(errX, errY); err != nil || !onP256(x, y) {
+				return nil, fmt.Errorf("invalid EC key %q", jwk.Kid)
+			}
+			keys = append(keys, verificationKey{Kid: jwk.Kid, Alg: "ES256", Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// onP256 reports whether (x, y) is a point of the P-256 curve.
+func onP256(x, y *big.Int) bool {
+	if x.BitLen() > 256 || y.BitLen() > 256 {
+		return false
+	}
+	point := make([]byte, 65)
+	point[0] = 4
+	x.FillBytes(point[1:33])
+	y.FillBytes(point[33:])
+	_, err := ecdh.P256().NewPublicKey(point)
+	return err == nil
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"products/config"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultJWTLeeway = 30 * time.Second
+
+var (
+	// ErrInvalidToken means the token is malformed or its signature does not
+	// verify.
+	ErrInvalidToken = errors.New("invalid bearer token")
+	// ErrTokenExpired and ErrTokenNotAccepted are returned for tokens with a
+	// valid signature whose claims are not accepted.
+	ErrTokenExpired     = errors.New("bearer token expired")
+	ErrTokenNotAccepted = errors.New("bearer token not accepted")
+)
+
+// tokenClaims are the registered claims checked on bearer tokens, and the
+// scope claims mapped to scopes: an OAuth "scope" string or a "scp" list.
+type tokenClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       []string        `json:"scp"`
+}
+
+// TokenScopes lists the scopes bearer tokens can be granted, configured as a
+// space-separated JWT_ALLOWED_SCOPES. Only products:read by default, so tokens
+// handed to browsers can read the catalog but never change it.
+func TokenScopes() []string {
+	allowed := strings.Fields(os.Getenv("JWT_ALLOWED_SCOPES"))
+	if len(allowed) == 0 {
+		return []string{ScopeProductsRead}
+	}
+	return allowed
+}
+
+// AuthenticateToken verifies a JWT bearer token signed with HS256 by one of
+// the comma-separated JWT_HS256_SECRETS, or with RS256 or ES256 by a key of
+// the JWKS file at JWT_JWKS_FILE. The token must carry an exp claim, and match
+// JWT_ISSUER and JWT_AUDIENCE when they are set. Its scopes are those of its
+// claims that are allowed by TokenScopes.
+func AuthenticateToken(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	credential, err := verifyToken(header.Alg, header.Kid, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims tokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenNotAccepted
+	}
+	if err := claims.validate(now); err != nil {
+		return nil, err
+	}
+
+	allowed := TokenScopes()
+	scopes := []string{}
+	for _, scope := range append(strings.Fields(claims.Scope), claims.Scp...) {
+		if slices.Contains(allowed, scope) && ValidScopes([]string{scope}) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &Principal{
+		Subject:    "jwt:" + claims.Issuer + ":" + claims.Subject,
+		Name:       claims.Subject,
+		Scopes:     scopes,
+		Credential: credential,
+	}, nil
+}
+
+// verifyToken checks the signature of a token and returns the name of the key
+// that made it. The algorithm decides which keys are tried, so a public key
+// can never be used as an HMAC secret.
+func verifyToken(alg, kid, signed string, signature []byte) (string, error) {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		for _, secret := range strings.Split(os.Getenv("JWT_HS256_SECRETS"), ",") {
+			if secret = strings.TrimSpace(secret); secret == "" {
+				continue
+			}
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(signed))
+			if hmac.Equal(signature, mac.Sum(nil)) {
+				return "jwt:hs256", nil
+			}
+		}
+	case "RS256", "ES256":
+		path := os.Getenv("JWT_JWKS_FILE")
+		if path == "" {
+			return "", ErrInvalidToken
+		}
+		keys, err := jwks.Keys(path)
+		if err != nil {
+			return "", fmt.Errorf("loading JWKS: %w", err)
+		}
+		for _, key := range keys {
+			if key.Alg != alg || (kid != "" && key.Kid != kid) {
+				continue
+			}
+			if verifyDigest(key, digest[:], signature) {
+				if key.Kid == "" {
+					return "jwt:" + strings.ToLower(alg), nil
+				}
+				return "jwt:" + key.Kid, nil
+			}
+		}
+	}
+	return "", ErrInvalidToken
+}
+
+func verifyDigest(key verificationKey, digest, signature []byte) bool {
+	switch public := key.Key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the 32-byte r and s values.
+		if len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, digest, r, s)
+	}
+	return false
+}
+
+func (claims tokenClaims) validate(now time.Time) error {
+	leeway := config.Duration("JWT_LEEWAY", defaultJWTLeeway)
+	if claims.ExpiresAt == nil || claims.Subject == "" {
+		return ErrTokenNotAccepted
+	}
+	if !now.Add(-leeway).Before(time.Unix(*claims.ExpiresAt, 0)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return ErrTokenNotAccepted
+	}
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" && claims.Issuer != issuer {
+		return ErrTokenNotAccepted
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" && !claims.hasAudience(audience) {
+		return ErrTokenNotAccepted
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list, includes
+// audience.
+func (claims tokenClaims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(claims.Audience, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(claims.Audience, &list) == nil {
+		return slices.Contains(list, audience)
+	}
+	return false
+}
+
+func decodeSegment(segment string, value any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, value)
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configuredKey is an API key taken from the environment.
+type configuredKey struct {
+	// Credential names the key in logs without revealing it.
+	Credential string
+	Key        string
+	ExpiresAt  *time.Time
+	// DeprecatedAt is set for keys listed in API_PREVIOUS_KEYS.
+	DeprecatedAt *time.Time
+}
+
+// previousKeysDeprecatedAt is when API_SECRET_KEY replaced the keys in
+// API_PREVIOUS_KEYS, read from API_SECRET_KEY_ROTATED_AT in RFC 3339. Without
+// it, previous keys are reported deprecated since the Unix epoch: they are
+// deprecated, but since an unknown time.
+func previousKeysDeprecatedAt() time.Time {
+	if rotatedAt, err := time.Parse(time.RFC3339, os.Getenv("API_SECRET_KEY_ROTATED_AT")); err == nil {
+		return rotatedAt
+	}
+	return time.Unix(0, 0)
+}
+
+// configuredKeys returns API_SECRET_KEY followed by the keys it replaced,
+// listed in API_PREVIOUS_KEYS as comma-separated "key" or
+// "key@<RFC3339 expiry>" entries. Previous keys keep working until their
+// expiry so consumers can move to the new key one at a time. Keys may contain
+// "@": only a suffix after the last one that parses as an expiry is taken as
+// such.
+func configuredKeys() []configuredKey {
+	var keys []configuredKey
+	if secret := os.Getenv("API_SECRET_KEY"); secret != "" {
+		keys = append(keys, configuredKey{Credential: "env:API_SECRET_KEY", Key: secret})
+	}
+
+	deprecatedAt := previousKeysDeprecatedAt()
+	for i, entry := range strings.Split(os.Getenv("API_PREVIOUS_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key := configuredKey{
+			Credential:   "env:API_PREVIOUS_KEYS#" + strconv.Itoa(i+1),
+			Key:          entry,
+			DeprecatedAt: &deprecatedAt,
+		}
+		if at := strings.LastIndex(entry, "@"); at > 0 {
+			if expiresAt, err := time.Parse(time.RFC3339, entry[at+1:]); err == nil {
+				key.Key, key.ExpiresAt = entry[:at], &expiresAt
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
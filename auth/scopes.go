@@ -0,0 +1,49 @@
+// Package auth identifies API callers and what they are allowed to do.
+package auth
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	ScopeStockWrite    = "stock:write"
+	ScopeImagesWrite   = "images:write"
+	ScopeAdmin         = "admin"
+)
+
+// Scopes lists every scope a credential can be granted.
+var Scopes = []string{ScopeProductsRead, ScopeProductsWrite, ScopeStockWrite, ScopeImagesWrite, ScopeAdmin}
+
+// ValidScopes reports whether every scope of scopes is known.
+func ValidScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Subject identifies the caller, such as api_key:<id>.
+	Subject string
+	Name    string
+	Scopes  []string
+	// Credential is the key the caller authenticated with, for logs. Callers
+	// using a rotated API_SECRET_KEY share a Subject but not a Credential.
+	Credential string
+	// ExpiresAt is when the credential stops working, if ever.
+	ExpiresAt *time.Time
+	// DeprecatedAt is set for credentials that were rotated and are only
+	// accepted until consumers move to their replacement.
+	DeprecatedAt *time.Time
+}
+
+// HasScope reports whether the caller was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"products/config"
+	"products/models"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSignatureSkew = 5 * time.Minute
+	minNonceLength       = 16
+	maxNonceLength       = 128
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrStaleTimestamp   = errors.New("request timestamp is outside the accepted clock skew")
+	ErrInvalidNonce     = errors.New("request nonce must be between 16 and 128 characters")
+	ErrReplayedNonce    = errors.New("request nonce was already used")
+)
+
+// SignedRequest is a request authenticated with an HMAC-SHA256 signature
+// instead of sending a key.
+type SignedRequest struct {
+	// KeyID is the ID of the issued API key whose signing secret signed the
+	// request, or empty when it was signed with API_SECRET_KEY.
+	KeyID  string
+	Method string
+	// Path is the request path including its query string.
+	Path      string
+	Timestamp string
+	Nonce     string
+	Body      []byte
+	Signature string
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the request with secret, over
+// its method, path, timestamp, nonce and the SHA-256 of its body, each on its
+// own line.
+func Sign(secret string, method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureSkew is how far the timestamp of a signed request may be from the
+// clock of the service, configured through REQUEST_SIGNATURE_SKEW.
+func SignatureSkew() time.Duration {
+	return config.Duration("REQUEST_SIGNATURE_SKEW", defaultSignatureSkew)
+}
+
+// VerifySignature authenticates a request signed with the signing secret of
+// the issued API key KeyID, which grants the scopes of the key, or with
+// API_SECRET_KEY or one of the previous keys still accepted, which grant
+// every scope. The timestamp, in Unix seconds, must be within SignatureSkew of
+// now, and each nonce can only be used once.
+func VerifySignature(db *gorm.DB, request SignedRequest, now time.Time) (*Principal, error) {
+	unix, err := strconv.ParseInt(request.Timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	skew := SignatureSkew()
+	timestamp := time.Unix(unix, 0)
+	if timestamp.Before(now.Add(-skew)) || timestamp.After(now.Add(skew)) {
+		return nil, ErrStaleTimestamp
+	}
+	if len(request.Nonce) < minNonceLength || len(request.Nonce) > maxNonceLength {
+		return nil, ErrInvalidNonce
+	}
+	signature, err := hex.DecodeString(request.Signature)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	if request.KeyID != "" {
+		return verifyKeySignature(db, request, signature, timestamp.Add(skew), now)
+	}
+
+	var matched *configuredKey
+	for _, configured := range configuredKeys() {
+		expected, _ := hex.DecodeString(Sign(configured.Key, request.Method, request.Path, request.Timestamp, request.Nonce, request.Body))
+		if hmac.Equal(signature, expected) && matched == nil {
+			matched = &configured
+		}
+	}
+	if matched == nil || (matched.ExpiresAt != nil && !now.Before(*matched.ExpiresAt)) {
+		return nil, ErrInvalidSignature
+	}
+
+	if err := useNonce(db, request.Nonce, timestamp.Add(skew), now); err != nil {
+		return nil, err
+	}
+	return &Principal{
+		Subject:      SharedSecretSubject,
+		Name:         "API_SECRET_KEY",
+		Scopes:       Scopes,
+		Credential:   matched.Credential + " (signed)",
+		ExpiresAt:    matched.ExpiresAt,
+		DeprecatedAt: matched.DeprecatedAt,
+	}, nil
+}
+
+// verifyKeySignature authenticates a request signed with the signing secret
+// of an issued, active API key.
+func verifyKeySignature(db *gorm.DB, request SignedRequest, signature []byte, nonceExpiresAt, now time.Time) (*Principal, error) {
+	id, err := uuid.Parse(request.KeyID)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	var apiKey models.APIKey
+	err = db.First(&apiKey, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSignature
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.SigningSecret == "" || !apiKey.Active(now) {
+		return nil, ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(Sign(apiKey.SigningSecret, request.Method, request.Path, request.Timestamp, request.Nonce, request.Body))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSignature
+	}
+
+	if err := useNonce(db, request.Nonce, nonceExpiresAt, now); err != nil {
+		return nil, err
+	}
+	recordUse(db, apiKey, now)
+	principal := apiKeyPrincipal(apiKey)
+	principal.Credential += " (signed)"
+	return principal, nil
+}
+
+// useNonce records nonce until expiresAt, failing with ErrReplayedNonce when
+// it was already used.
+func useNonce(db *gorm.DB, nonce string, expiresAt, now time.Time) error {
+	if err := db.Where("expires_at < ?", now).Delete(&models.RequestNonce{}).Error; err != nil {
+		return err
+	}
+
+	record := models.RequestNonce{NonceHash: HashKey(nonce), ExpiresAt: expiresAt}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReplayedNonce
+	}
+	return nil
+}
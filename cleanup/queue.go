@@ -0,0 +1,240 @@
+// Package cleanup removes files from the image storage backends once nothing
+// references them. Deletions are queued in the database and processed in the
+// background, so a slow or failing backend neither blocks requests nor loses
+// deletions.
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"products/config"
+	"products/models"
+	"products/storage"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 8
+	baseBackoff        = 30 * time.Second
+	maxBackoff         = 6 * time.Hour
+	// lease is how long a claimed job is hidden from other workers while its
+	// deletion is attempted.
+	lease = 5 * time.Minute
+)
+
+// ProcessResult summarizes a pass over the due jobs.
+type ProcessResult struct {
+	Deleted int
+	Retried int
+	Failed  int
+}
+
+// QueueStatus describes the deletion queue.
+type QueueStatus struct {
+	Pending       int64                     `json:"pending"`
+	Retrying      int64                     `json:"retrying"`
+	Failed        int64                     `json:"failed"`
+	OldestPending *time.Time                `json:"oldest_pending,omitempty"`
+	RecentFailed  []models.ImageDeletionJob `json:"recent_failed"`
+}
+
+// MaxAttempts is how many times a deletion is tried before the job is marked
+// failed, configured through IMAGE_DELETION_MAX_ATTEMPTS.
+func MaxAttempts() int {
+	return int(config.Int64("IMAGE_DELETION_MAX_ATTEMPTS", defaultMaxAttempts))
+}
+
+// Backoff is the delay before the next try of a job that failed attempts
+// times: 30s, doubling with every attempt up to 6h.
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// EnqueueImages queues the deletion of images and their renditions from the
+// backend they were uploaded to. Call it in the transaction that deletes the
+// images so the files are only removed once the change is committed. Images
+// saved before their backend was recorded are deleted from the store serving
+// their URL.
+func EnqueueImages(tx *gorm.DB, images ...models.ProductImage) error {
+	var jobs []models.ImageDeletionJob
+	now := time.Now()
+	for _, image := range images {
+		if image.StorageBackend == "" {
+			store, publicID, err := storage.ForURL(image.URL)
+			if err != nil {
+				log.Printf("Not deleting image %s: %s", image.ID, err)
+				continue
+			}
+			image.StorageBackend = store.Backend()
+			if image.PublicID == "" {
+				image.PublicID = publicID
+			}
+		}
+		for _, publicID := range storedPublicIDs(image) {
+			jobs = append(jobs, models.ImageDeletionJob{
+				StorageBackend: image.StorageBackend,
+				PublicID:       publicID,
+				Status:         models.ImageDeletionPending,
+				NextAttemptAt:  now,
+			})
+		}
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	return tx.Create(&jobs).Error
+}
+
+// EnqueueURL queues the deletion of an image only referenced by its URL, such
+// as the image_url of products uploaded before galleries existed, from the
+// store serving it. URLs no store serves are not ours to delete.
+func EnqueueURL(tx *gorm.DB, url string) error {
+	store, publicID, err := storage.ForURL(url)
+	if err != nil {
+		return nil
+	}
+	return EnqueueFile(tx, store.Backend(), publicID)
+}
+
+// EnqueueFile queues the deletion of a single file, such as a direct upload
+// that could not be removed once it was stored as an image.
+func EnqueueFile(tx *gorm.DB, backend, publicID string) error {
+	return tx.Create(&models.ImageDeletionJob{
+		StorageBackend: backend,
+		PublicID:       publicID,
+		Status:         models.ImageDeletionPending,
+		NextAttemptAt:  time.Now(),
+	}).Error
+}
+
+// Process tries up to limit jobs that are due at now. Successful jobs are
+// removed; failed ones are retried after Backoff until MaxAttempts.
+func Process(ctx context.Context, db *gorm.DB, now time.Time, limit int) (ProcessResult, error) {
+	var result ProcessResult
+
+	jobs, err := claim(db, now, limit)
+	if err != nil {
+		return result, err
+	}
+
+	maxAttempts := MaxAttempts()
+	for _, job := range jobs {
+		err := deleteFile(ctx, job)
+		if err == nil {
+			if err := db.Delete(&models.ImageDeletionJob{}, job.ID).Error; err != nil {
+				return result, err
+			}
+			result.Deleted++
+			continue
+		}
+
+		message := err.Error()
+		updates := map[string]interface{}{
+			"attempts":   job.Attempts + 1,
+			"last_error": message,
+		}
+		if job.Attempts+1 >= maxAttempts {
+			updates["status"] = models.ImageDeletionFailed
+			result.Failed++
+		} else {
+			updates["next_attempt_at"] = now.Add(Backoff(job.Attempts + 1))
+			result.Retried++
+		}
+		if err := db.Model(&models.ImageDeletionJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Status reports the size of the queue and its latest failures.
+func Status(db *gorm.DB) (QueueStatus, error) {
+	status := QueueStatus{RecentFailed: []models.ImageDeletionJob{}}
+	jobs := db.Model(&models.ImageDeletionJob{})
+
+	err := jobs.Session(&gorm.Session{}).Where("status = ?", models.ImageDeletionPending).Count(&status.Pending).Error
+	if err != nil {
+		return status, err
+	}
+	err = jobs.Session(&gorm.Session{}).Where("status = ? AND attempts > 0", models.ImageDeletionPending).Count(&status.Retrying).Error
+	if err != nil {
+		return status, err
+	}
+	err = jobs.Session(&gorm.Session{}).Where("status = ?", models.ImageDeletionFailed).Count(&status.Failed).Error
+	if err != nil {
+		return status, err
+	}
+
+	var oldest models.ImageDeletionJob
+	err = db.Where("status = ?", models.ImageDeletionPending).Order("created_at ASC").First(&oldest).Error
+	if err == nil {
+		status.OldestPending = &oldest.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return status, err
+	}
+
+	err = db.Where("status = ?", models.ImageDeletionFailed).
+		Order("updated_at DESC").
+		Limit(20).
+		Find(&status.RecentFailed).Error
+	return status, err
+}
+
+// claim picks the due jobs and pushes their next attempt past the lease, so
+// concurrent workers do not try the same deletions.
+func claim(db *gorm.DB, now time.Time, limit int) ([]models.ImageDeletionJob, error) {
+	var jobs []models.ImageDeletionJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.ImageDeletionPending, now).
+			Order("next_attempt_at ASC").
+			Limit(limit).
+			Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return tx.Model(&models.ImageDeletionJob{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return jobs, err
+}
+
+func deleteFile(ctx context.Context, job models.ImageDeletionJob) error {
+	// Jobs queued before backends were resolved on enqueue have none; their
+	// images came from the configured store.
+	if job.StorageBackend == "" && storage.Images != nil {
+		return storage.Images.Delete(ctx, job.PublicID)
+	}
+	store, err := storage.For(job.StorageBackend)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, job.PublicID)
+}
+
+// storedPublicIDs lists the files stored for an image: the original and its
+// renditions.
+func storedPublicIDs(image models.ProductImage) []string {
+	ids := make([]string, 0, len(image.Renditions)+1)
+	if image.PublicID != "" {
+		ids = append(ids, image.PublicID)
+	}
+	for _, rendition := range image.Renditions {
+		if rendition.PublicID != "" {
+			ids = append(ids, rendition.PublicID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,25 @@
+package cleanup
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 30 * time.Second},
+		{attempts: 2, expected: time.Minute},
+		{attempts: 5, expected: 8 * time.Minute},
+		{attempts: 10, expected: 256 * time.Minute},
+		{attempts: 11, expected: 6 * time.Hour},
+		{attempts: 100, expected: 6 * time.Hour},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, Backoff(tc.attempts), "attempts: %d", tc.attempts)
+	}
+}
@@ -0,0 +1,118 @@
+// Command backfill-image-ids records the storage backend of product images
+// saved before it was stored alongside their public ID, and turns images only
+// referenced by a product image_url into primary gallery images, so they can
+// be deleted without parsing their URL.
+//
+// Usage:
+//
+//	go run ./cmd/backfill-image-ids [-dry-run]
+package main
+
+import (
+	"flag"
+	"gorm.io/gorm"
+	"log"
+	"products/database"
+	"products/models"
+	"products/storage"
+)
+
+type backfillResult struct {
+	Images   int
+	Products int
+	Skipped  int
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the rows that would change without saving them")
+	flag.Parse()
+
+	database.Connect()
+	storage.Setup()
+
+	result, err := backfill(database.DB, *dryRun)
+	if err != nil {
+		log.Fatalf("Backfill failed: %s", err)
+	}
+	verb := "Updated"
+	if *dryRun {
+		verb = "Would update"
+	}
+	log.Printf("%s %d images and %d products, skipped %d", verb, result.Images, result.Products, result.Skipped)
+}
+
+// backfill fills in the storage backend, and the public ID when it is
+// missing, of every image without a backend, then creates a primary image for
+// every product with an image_url but no gallery. URLs no configured backend
+// recognizes are logged and skipped.
+func backfill(db *gorm.DB, dryRun bool) (backfillResult, error) {
+	var result backfillResult
+
+	var images []models.ProductImage
+	if err := db.Where("storage_backend = ''").Find(&images).Error; err != nil {
+		return result, err
+	}
+	for _, image := range images {
+		backend, publicID := locate(image.URL)
+		if backend == "" {
+			log.Printf("Skipping image %s: no storage backend recognizes %s", image.ID, image.URL)
+			result.Skipped++
+			continue
+		}
+		if image.PublicID != "" {
+			publicID = image.PublicID
+		}
+		log.Printf("Image %s: backend %s, public_id %s", image.ID, backend, publicID)
+		result.Images++
+		if dryRun {
+			continue
+		}
+		err := db.Model(&models.ProductImage{}).Where("id = ?", image.ID).
+			Updates(map[string]interface{}{"storage_backend": backend, "public_id": publicID}).Error
+		if err != nil {
+			return result, err
+		}
+	}
+
+	var products []models.Product
+	err := db.Where("image_url IS NOT NULL AND image_url <> ''").
+		Where("NOT EXISTS (SELECT 1 FROM product_images WHERE product_images.product_id = products.id)").
+		Find(&products).Error
+	if err != nil {
+		return result, err
+	}
+	for _, product := range products {
+		backend, publicID := locate(*product.ImageURL)
+		if backend == "" {
+			log.Printf("Skipping product %s: no storage backend recognizes %s", product.ID, *product.ImageURL)
+			result.Skipped++
+			continue
+		}
+		log.Printf("Product %s: primary image on backend %s, public_id %s", product.ID, backend, publicID)
+		result.Products++
+		if dryRun {
+			continue
+		}
+		image := models.ProductImage{
+			ProductID:      product.ID,
+			URL:            *product.ImageURL,
+			PublicID:       publicID,
+			StorageBackend: backend,
+			IsPrimary:      true,
+		}
+		if err := db.Create(&image).Error; err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// locate finds the backend an image URL was stored in, and its public ID.
+func locate(url string) (string, string) {
+	for _, store := range storage.Backends() {
+		if publicID := store.PublicID(url); publicID != "" {
+			return store.Backend(), publicID
+		}
+	}
+	return "", ""
+}
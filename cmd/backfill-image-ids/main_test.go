@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"products/models"
+	"products/storage"
+	"testing"
+)
+
+func setupBackfill(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Product{}, &models.ProductImage{}); err != nil {
+		t.Fatalf("failed to auto migrate products: %v", err)
+	}
+
+	local, err := storage.NewLocalStore(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatalf("failed to create image storage: %v", err)
+	}
+	storage.Images = local
+	storage.Register(local)
+	return db
+}
+
+func TestBackfill(t *testing.T) {
+	db := setupBackfill(t)
+
+	legacyURL := "/uploads/sabordarondonia/legado.jpg"
+	legacy := models.Product{Name: "Farinha d'Água", Price: 800, ImageURL: &legacyURL}
+	unknownURL := "https://example.com/bolo.jpg"
+	unknown := models.Product{Name: "Bolo de Macaxeira", Price: 1500, ImageURL: &unknownURL}
+	withGallery := models.Product{Name: "Tucumã", Price: 600}
+	db.Create(&legacy)
+	db.Create(&unknown)
+	db.Create(&withGallery)
+	image := models.ProductImage{ProductID: withGallery.ID, URL: "/uploads/sabordarondonia/tucuma.png", PublicID: "sabordarondonia/tucuma.png", IsPrimary: true}
+	db.Create(&image)
+
+	expected := backfillResult{Images: 1, Products: 1, Skipped: 1}
+
+	result, err := backfill(db, true)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+	var count int64
+	db.Model(&models.ProductImage{}).Where("storage_backend = ''").Count(&count)
+	assert.Equal(t, int64(1), count, "a dry run changes nothing")
+
+	result, err = backfill(db, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, result)
+
+	var updated models.ProductImage
+	db.First(&updated, image.ID)
+	assert.Equal(t, storage.BackendLocal, updated.StorageBackend)
+	assert.Equal(t, "sabordarondonia/tucuma.png", updated.PublicID)
+
+	var created models.ProductImage
+	assert.NoError(t, db.Where("product_id = ?", legacy.ID).First(&created).Error)
+	assert.Equal(t, storage.BackendLocal, created.StorageBackend)
+	assert.Equal(t, "sabordarondonia/legado.jpg", created.PublicID)
+	assert.True(t, created.IsPrimary)
+
+	result, err = backfill(db, false)
+	assert.NoError(t, err)
+	assert.Equal(t, backfillResult{Skipped: 1}, result, "running again only skips the unknown URL")
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration reads a duration such as "15m" from the environment, falling back
+// to the given value when the variable is unset or invalid.
+func Duration(key string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		log.Printf("Invalid duration %q for %s, using %s", raw, key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// Int64 reads a positive integer from the environment, falling back to the
+// given value when the variable is unset or invalid.
+func Int64(key string, fallback int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value <= 0 {
+		log.Printf("Invalid value %q for %s, using %d", raw, key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// Bool reads a boolean from the environment, falling back to the given value
+// when the variable is unset or invalid.
+func Bool(key string, fallback bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Invalid boolean %q for %s, using %t", raw, key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// List reads a comma-separated list from the environment, without empty
+// entries.
+func List(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
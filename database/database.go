@@ -12,6 +12,12 @@ import (
 
 var DB *gorm.DB
 
+// ProductSearchVector is the weighted tsvector products are searched by. It
+// must match the expression of the idx_products_search index so Postgres can
+// use it.
+const ProductSearchVector = "(setweight(to_tsvector('simple', products_unaccent(coalesce(name, ''))), 'A') || " +
+	"setweight(to_tsvector('simple', products_unaccent(coalesce(description, ''))), 'B'))"
+
 func Connect() {
 	var err error
 
@@ -35,8 +41,47 @@ func Connect() {
 	fmt.Println("Successfully connected to database!")
 
 	fmt.Println("Running Migrations...")
-	err = DB.AutoMigrate(&models.Product{})
+	err = DB.AutoMigrate(
+		&models.Category{},
+		&models.Product{},
+		&models.Variant{},
+		&models.ProductImage{},
+		&models.Reservation{},
+		&models.ReservationLine{},
+		&models.IdempotencyRecord{},
+		&models.StockMovement{},
+		&models.ImageDeletionJob{},
+		&models.APIKey{},
+		&models.RequestNonce{},
+		&models.ImageUploadTicket{},
+	)
 	if err != nil {
 		log.Fatal("Failed to run migrations! \n", err)
 	}
+
+	fmt.Println("Setting up product search...")
+	err = setupSearch(DB)
+	if err != nil {
+		log.Fatal("Failed to set up product search! \n", err)
+	}
+}
+
+// setupSearch installs the unaccent extension and the full-text index used to
+// search products. unaccent is not immutable, so it is wrapped in a function
+// that can be used in an index expression.
+func setupSearch(db *gorm.DB) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS unaccent",
+		`CREATE OR REPLACE FUNCTION products_unaccent(text) RETURNS text
+			AS $$ SELECT public.unaccent('public.unaccent', $1) $$
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT`,
+		"CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN (" + ProductSearchVector + ")",
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"products/cleanup"
+	"products/database"
+)
+
+// GetImageDeletionQueue godoc
+// @Summary      Image deletion queue status
+// @Description  Report how many image files are waiting to be deleted from the storage backends, how many are being retried, and the latest deletions that ran out of attempts
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  cleanup.QueueStatus
+// @Security     ApiKeyAuth
+// @Router       /admin/image-deletions [get]
+func GetImageDeletionQueue(c *fiber.Ctx) error {
+	status, err := cleanup.Status(database.DB)
+	if err != nil {
+		log.Printf("Error getting image deletion queue: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not get image deletion queue"})
+	}
+	return c.JSON(status)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"products/cleanup"
+	"products/database"
+	"products/models"
+	"testing"
+	"time"
+)
+
+func TestImageDeletionQueue(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM image_deletion_jobs")
+	t.Setenv("IMAGE_DELETION_MAX_ATTEMPTS", "2")
+
+	// Files of a backend that is not configured cannot be deleted.
+	image := models.ProductImage{PublicID: "sabordarondonia/antigo.jpg", StorageBackend: "s3"}
+	assert.NoError(t, cleanup.EnqueueImages(database.DB, image))
+
+	queue := func() cleanup.QueueStatus {
+		status, body := sendJSON(t, app, http.MethodGet, "/api/admin/image-deletions", "")
+		assert.Equal(t, fiber.StatusOK, status)
+		var returned cleanup.QueueStatus
+		assert.NoError(t, json.Unmarshal(body, &returned))
+		return returned
+	}
+	assert.Equal(t, int64(1), queue().Pending)
+
+	now := time.Now()
+	result, err := cleanup.Process(context.Background(), database.DB, now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, cleanup.ProcessResult{Retried: 1}, result)
+	returned := queue()
+	assert.Equal(t, int64(1), returned.Retrying)
+	assert.NotNil(t, returned.OldestPending)
+
+	// The retry waits for the backoff.
+	result, err = cleanup.Process(context.Background(), database.DB, now.Add(time.Second), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, cleanup.ProcessResult{}, result)
+
+	result, err = cleanup.Process(context.Background(), database.DB, now.Add(cleanup.Backoff(1)), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, cleanup.ProcessResult{Failed: 1}, result)
+
+	returned = queue()
+	assert.Equal(t, int64(0), returned.Pending)
+	assert.Equal(t, int64(1), returned.Failed)
+	if assert.Len(t, returned.RecentFailed, 1) {
+		assert.Equal(t, 2, returned.RecentFailed[0].Attempts)
+		assert.Contains(t, *returned.RecentFailed[0].LastError, "not configured")
+	}
+}
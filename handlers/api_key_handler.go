@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"products/auth"
+	"products/config"
+	"products/database"
+	"products/models"
+	"strings"
+	"time"
+)
+
+const defaultRotationGrace = 24 * time.Hour
+
+var errKeyRotated = errors.New("API key was already rotated")
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type RotateAPIKeyRequest struct {
+	// ExpiresAt is the expiry of the new key.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// PreviousExpiresAt is when the rotated key stops working. It defaults to
+	// API_KEY_ROTATION_GRACE from now.
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey models.APIKey `json:"api_key"`
+	// Key is the secret to send in X-API-Key. It is not shown again.
+	Key string `json:"key"`
+	// SigningSecret signs requests sent with the key ID in X-Key-ID instead
+	// of the key. It is not shown again.
+	SigningSecret string `json:"signing_secret"`
+}
+
+// CreateAPIKey godoc
+// @Summary      Issue an API key
+// @Description  Create a named API key granted the given scopes (products:read, products:write, stock:write, images:write, admin), optionally expiring. The key and its signing secret are only returned in this response.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        request  body      CreateAPIKeyRequest  true  "Name, scopes and expiry of the key"
+// @Success      201      {object}  CreateAPIKeyResponse
+// @Failure      400      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /admin/api-keys [post]
+func CreateAPIKey(c *fiber.Ctx) error {
+	db := database.DB
+	payload := new(CreateAPIKeyRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "API key name is required"})
+	}
+	if len(payload.Scopes) == 0 || !auth.ValidScopes(payload.Scopes) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "scopes must list one or more of " + strings.Join(auth.Scopes, ", ")})
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_at must be in the future"})
+	}
+
+	key, hash, err := auth.GenerateKey()
+	if err != nil {
+		log.Printf("Error generating API key: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create API key"})
+	}
+	signingSecret, err := auth.GenerateSigningSecret()
+	if err != nil {
+		log.Printf("Error generating API key signing secret: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create API key"})
+	}
+	apiKey := models.APIKey{
+		Name:          payload.Name,
+		Prefix:        auth.DisplayPrefix(key),
+		KeyHash:       hash,
+		SigningSecret: signingSecret,
+		Scopes:        payload.Scopes,
+		ExpiresAt:     payload.ExpiresAt,
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		log.Printf("Error creating API key in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create API key"})
+	}
+
+	log.Printf("Issued API key %s (%s) with scopes %v", apiKey.ID, apiKey.Name, apiKey.Scopes)
+	return c.Status(fiber.StatusCreated).JSON(CreateAPIKeyResponse{APIKey: apiKey, Key: key, SigningSecret: signingSecret})
+}
+
+// RotateAPIKey godoc
+// @Summary      Rotate an API key
+// @Description  Issue a new key with the name and scopes of an active key. The old key keeps working until previous_expires_at, API_KEY_ROTATION_GRACE from now by default, and callers using it get Deprecation and Sunset headers. The new key and its signing secret are only returned in this response.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string               true   "API key ID (UUID)"
+// @Param        request  body      RotateAPIKeyRequest  false  "Expiry of the new and old keys"
+// @Success      201      {object}  CreateAPIKeyResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /admin/api-keys/{id}/rotate [post]
+func RotateAPIKey(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(RotateAPIKeyRequest)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+		}
+	}
+	now := time.Now()
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(now) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_at must be in the future"})
+	}
+	if payload.PreviousExpiresAt != nil && payload.PreviousExpiresAt.Before(now) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "previous_expires_at cannot be in the past"})
+	}
+
+	var previous models.APIKey
+	if err := db.First(&previous, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if !previous.Active(now) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "API key is expired or revoked"})
+	}
+
+	previousExpiresAt := now.Add(config.Duration("API_KEY_ROTATION_GRACE", defaultRotationGrace))
+	if payload.PreviousExpiresAt != nil {
+		previousExpiresAt = *payload.PreviousExpiresAt
+	}
+	// Rotating never extends the life of the old key.
+	if previous.ExpiresAt != nil && previous.ExpiresAt.Before(previousExpiresAt) {
+		previousExpiresAt = *previous.ExpiresAt
+	}
+
+	key, hash, err := auth.GenerateKey()
+	if err != nil {
+		log.Printf("Error generating API key: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not rotate API key"})
+	}
+	signingSecret, err := auth.GenerateSigningSecret()
+	if err != nil {
+		log.Printf("Error generating API key signing secret: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not rotate API key"})
+	}
+	apiKey := models.APIKey{
+		Name:          previous.Name,
+		Prefix:        auth.DisplayPrefix(key),
+		KeyHash:       hash,
+		SigningSecret: signingSecret,
+		Scopes:        previous.Scopes,
+		ExpiresAt:     payload.ExpiresAt,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&apiKey).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.APIKey{}).
+			Where("id = ? AND replaced_by_id IS NULL", previous.ID).
+			Updates(map[string]interface{}{"replaced_by_id": apiKey.ID, "expires_at": previousExpiresAt, "rotated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errKeyRotated
+		}
+		return nil
+	})
+	if errors.Is(err, errKeyRotated) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		log.Printf("Error rotating API key in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not rotate API key"})
+	}
+
+	log.Printf("Rotated API key %s (%s) to %s; the old key expires at %s", previous.ID, previous.Name, apiKey.ID, previousExpiresAt.Format(time.RFC3339))
+	return c.Status(fiber.StatusCreated).JSON(CreateAPIKeyResponse{APIKey: apiKey, Key: key, SigningSecret: signingSecret})
+}
+
+// GetAPIKeys godoc
+// @Summary      List API keys
+// @Description  Return every issued API key, including expired and revoked ones, without their secrets
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}   models.APIKey
+// @Security     ApiKeyAuth
+// @Router       /admin/api-keys [get]
+func GetAPIKeys(c *fiber.Ctx) error {
+	db := database.DB
+	keys := []models.APIKey{}
+	if err := db.Order("created_at ASC").Find(&keys).Error; err != nil {
+		log.Printf("Error getting API keys in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch API keys"})
+	}
+	return c.JSON(keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke an API key
+// @Description  Stop accepting an API key immediately. Revoking a revoked key keeps its original revocation time.
+// @Tags         admin
+// @Param        id   path      string  true  "API key ID (UUID)"
+// @Success      204  {object}  nil
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /admin/api-keys/{id} [delete]
+func RevokeAPIKey(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var apiKey models.APIKey
+	if err := db.First(&apiKey, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if apiKey.RevokedAt == nil {
+		err := db.Model(&models.APIKey{}).Where("id = ?", id).Update("revoked_at", time.Now()).Error
+		if err != nil {
+			log.Printf("Error revoking API key: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke API key"})
+		}
+		log.Printf("Revoked API key %s (%s)", apiKey.ID, apiKey.Name)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"products/auth"
+	"products/database"
+	"products/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM api_keys")
+
+	testCases := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Scoped Key",
+			payload:        `{"name": "storefront", "scopes": ["products:read"]}`,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Success - Expiring Key",
+			payload:        `{"name": "import job", "scopes": ["products:write", "images:write"], "expires_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Failure - Missing Name",
+			payload:        `{"name": " ", "scopes": ["products:read"]}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - No Scopes",
+			payload:        `{"name": "storefront"}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Unknown Scope",
+			payload:        `{"name": "storefront", "scopes": ["products:delete"]}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Expired",
+			payload:        `{"name": "storefront", "scopes": ["products:read"], "expires_at": "2020-01-01T00:00:00Z"}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := sendJSON(t, app, http.MethodPost, "/api/admin/api-keys", tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+			if tc.expectedStatus != fiber.StatusCreated {
+				return
+			}
+
+			var created CreateAPIKeyResponse
+			assert.NoError(t, json.Unmarshal(body, &created))
+			assert.True(t, strings.HasPrefix(created.Key, created.APIKey.Prefix))
+			assert.NotContains(t, string(body), auth.HashKey(created.Key), "the hash is never returned")
+			assert.True(t, strings.HasPrefix(created.SigningSecret, "ss_"))
+
+			principal, err := auth.Authenticate(database.DB, created.Key)
+			assert.NoError(t, err)
+			assert.Equal(t, created.APIKey.Scopes, principal.Scopes)
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM api_keys")
+
+	status, body := sendJSON(t, app, http.MethodPost, "/api/admin/api-keys", `{"name": "storefront", "scopes": ["products:read"]}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	var created CreateAPIKeyResponse
+	assert.NoError(t, json.Unmarshal(body, &created))
+
+	status, body = sendJSON(t, app, http.MethodGet, "/api/admin/api-keys", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var keys []models.APIKey
+	assert.NoError(t, json.Unmarshal(body, &keys))
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "storefront", keys[0].Name)
+		assert.Nil(t, keys[0].RevokedAt)
+	}
+
+	target := "/api/admin/api-keys/" + created.APIKey.ID.String()
+	status, _ = sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	_, err := auth.Authenticate(database.DB, created.Key)
+	assert.ErrorIs(t, err, auth.ErrInvalidKey)
+
+	status, body = sendJSON(t, app, http.MethodGet, "/api/admin/api-keys", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.NoError(t, json.Unmarshal(body, &keys))
+	if assert.Len(t, keys, 1) {
+		assert.NotNil(t, keys[0].RevokedAt)
+	}
+
+	status, _ = sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNoContent, status, "revoking twice is harmless")
+
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/admin/api-keys/c6c7b0a5-5f0e-4d8c-9a53-1d1b2a0f8e11", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM api_keys")
+
+	status, body := sendJSON(t, app, http.MethodPost, "/api/admin/api-keys", `{"name": "storefront", "scopes": ["products:read"]}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	var previous CreateAPIKeyResponse
+	assert.NoError(t, json.Unmarshal(body, &previous))
+	target := "/api/admin/api-keys/" + previous.APIKey.ID.String() + "/rotate"
+
+	status, _ = sendJSON(t, app, http.MethodPost, target, `{"previous_expires_at": "2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, body = sendJSON(t, app, http.MethodPost, target, "")
+	assert.Equal(t, fiber.StatusCreated, status)
+	var rotated CreateAPIKeyResponse
+	assert.NoError(t, json.Unmarshal(body, &rotated))
+	assert.Equal(t, "storefront", rotated.APIKey.Name)
+	assert.Equal(t, previous.APIKey.Scopes, rotated.APIKey.Scopes)
+	assert.NotEqual(t, previous.Key, rotated.Key)
+	assert.NotEmpty(t, rotated.SigningSecret)
+	assert.NotEqual(t, previous.SigningSecret, rotated.SigningSecret)
+
+	// Both keys work during the grace period; the old one is deprecated.
+	principal, err := auth.Authenticate(database.DB, previous.Key)
+	assert.NoError(t, err)
+	if assert.NotNil(t, principal.DeprecatedAt) {
+		assert.WithinDuration(t, time.Now(), *principal.DeprecatedAt, time.Minute)
+	}
+	if assert.NotNil(t, principal.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(24*time.Hour), *principal.ExpiresAt, time.Minute)
+	}
+	principal, err = auth.Authenticate(database.DB, rotated.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, principal.DeprecatedAt)
+
+	var stored models.APIKey
+	assert.NoError(t, database.DB.First(&stored, previous.APIKey.ID).Error)
+	if assert.NotNil(t, stored.ReplacedByID) {
+		assert.Equal(t, rotated.APIKey.ID, *stored.ReplacedByID)
+	}
+
+	status, _ = sendJSON(t, app, http.MethodPost, target, "")
+	assert.Equal(t, fiber.StatusConflict, status, "a key is rotated once")
+
+	// Once the grace period is over only the new key works.
+	database.DB.Model(&models.APIKey{}).Where("id = ?", previous.APIKey.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = auth.Authenticate(database.DB, previous.Key)
+	assert.ErrorIs(t, err, auth.ErrInvalidKey)
+}
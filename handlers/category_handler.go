@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"products/database"
+	"products/models"
+)
+
+// categoryDescendantsSQL selects the id of a category and of every category
+// below it in the tree.
+const categoryDescendantsSQL = `WITH RECURSIVE category_tree AS (
+	SELECT id FROM categories WHERE id = ?
+	UNION ALL
+	SELECT categories.id FROM categories JOIN category_tree ON categories.parent_id = category_tree.id
+) SELECT id FROM category_tree`
+
+var (
+	errParentNotFound = errors.New("parent category not found")
+	errCategoryCycle  = errors.New("a category cannot be moved below itself or one of its descendants")
+)
+
+type CategoryRequest struct {
+	Name     string     `json:"name"`
+	ParentID *uuid.UUID `json:"parent_id,omitempty"`
+}
+
+type ProductCategoriesRequest struct {
+	CategoryIDs []uuid.UUID `json:"category_ids"`
+}
+
+// CreateCategory godoc
+// @Summary      Create a Category
+// @Description  Add a category, optionally below a parent category
+// @Tags         categories
+// @Accept       json
+// @Produce      json
+// @Param        category  body      CategoryRequest  true  "Category data"
+// @Success      201       {object}  models.Category
+// @Failure      400       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /categories [post]
+func CreateCategory(c *fiber.Ctx) error {
+	db := database.DB
+	payload := new(CategoryRequest)
+
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if payload.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Category name is required"})
+	}
+
+	if payload.ParentID != nil {
+		err := db.First(&models.Category{}, *payload.ParentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Parent category not found"})
+		}
+		if err != nil {
+			log.Printf("Error getting parent category in database: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create category"})
+		}
+	}
+
+	category := models.Category{Name: payload.Name, ParentID: payload.ParentID}
+	if err := db.Create(&category).Error; err != nil {
+		if isUniqueViolation(db, err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A category with this name already exists under the same parent"})
+		}
+		log.Printf("Error creating category in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create category"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(category)
+}
+
+// GetCategories godoc
+// @Summary      List Categories
+// @Description  Return all categories as a flat list, or as a tree of root categories with nested children when tree=true
+// @Tags         categories
+// @Produce      json
+// @Param        tree  query     bool  false  "Return the categories as a tree"
+// @Success      200   {array}   models.Category
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /categories [get]
+func GetCategories(c *fiber.Ctx) error {
+	db := database.DB
+
+	categories := []models.Category{}
+	if err := db.Order("name ASC").Find(&categories).Error; err != nil {
+		log.Printf("Error getting categories in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch categories"})
+	}
+
+	if c.QueryBool("tree") {
+		return c.JSON(buildCategoryTree(categories, nil))
+	}
+	return c.JSON(categories)
+}
+
+// GetCategoryByID godoc
+// @Summary      Find category by id
+// @Description  Return a category with its direct children
+// @Tags         categories
+// @Produce      json
+// @Param        id   path      string  true  "Category ID (UUID)"
+// @Success      200  {object}  models.Category
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /categories/{id} [get]
+func GetCategoryByID(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var category models.Category
+	if err := db.Preload("Children", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name ASC")
+	}).First(&category, id).Error; err != nil {
+		log.Printf("Error getting category in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	return c.JSON(category)
+}
+
+// PatchCategory godoc
+// @Summary      Update a Category
+// @Description  Rename a category or move it below another parent. Send parent_id null to make it a root category.
+// @Tags         categories
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string           true  "Category ID (UUID)"
+// @Param        category  body      CategoryRequest  true  "New Category Data"
+// @Success      200       {object}  models.Category
+// @Failure      400       {object}  map[string]string
+// @Failure      404       {object}  map[string]string
+// @Failure      409       {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /categories/{id} [patch]
+func PatchCategory(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var category models.Category
+	if err := db.First(&category, id).Error; err != nil {
+		log.Printf("Error getting category in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	updateData := make(map[string]interface{})
+	if err := c.BodyParser(&updateData); err != nil {
+		log.Printf("Error parsing patch request body: %s", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	updates := make(map[string]interface{})
+	if name, ok := updateData["name"]; ok {
+		if name, ok := name.(string); !ok || name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Category name is required"})
+		}
+		updates["name"] = name
+	}
+	if rawParent, ok := updateData["parent_id"]; ok {
+		parentID, err := parseParentID(rawParent)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if parentID != nil {
+			err := validateCategoryParent(db, id, *parentID)
+			if errors.Is(err, errParentNotFound) || errors.Is(err, errCategoryCycle) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+			}
+			if err != nil {
+				log.Printf("Error validating category parent in database: %s", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update category"})
+			}
+		}
+		updates["parent_id"] = parentID
+	}
+
+	if err := db.Model(&category).Updates(updates).Error; err != nil {
+		if isUniqueViolation(db, err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A category with this name already exists under the same parent"})
+		}
+		log.Printf("Error updating category in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update category"})
+	}
+	return c.JSON(category)
+}
+
+// DeleteCategory godoc
+// @Summary      Delete a Category
+// @Description  Remove a category and its product associations. Categories that still have children cannot be deleted.
+// @Tags         categories
+// @Param        id   path      string  true  "Category ID (UUID)"
+// @Success      204  {object}  nil
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /categories/{id} [delete]
+func DeleteCategory(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var category models.Category
+	if err := db.First(&category, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Category not found"})
+	}
+
+	var children int64
+	if err := db.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+		log.Printf("Error counting subcategories: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete category"})
+	}
+	if children > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Category has subcategories"})
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM product_categories WHERE category_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&category).Error
+	})
+	if err != nil {
+		log.Printf("Error deleting category: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete category"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetProductCategories godoc
+// @Summary      Set the categories of a Product
+// @Description  Replace the categories a product belongs to
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                    true  "Product ID (UUID)"
+// @Param        request  body      ProductCategoriesRequest  true  "IDs of the categories"
+// @Success      200      {object}  models.Product
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/categories [put]
+func SetProductCategories(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(ProductCategoriesRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	var product models.Product
+	if err := db.First(&product, id).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	categories := []models.Category{}
+	if len(payload.CategoryIDs) > 0 {
+		if err := db.Where("id IN (?)", payload.CategoryIDs).Find(&categories).Error; err != nil {
+			log.Printf("Error getting categories in database: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update product categories"})
+		}
+	}
+	if len(categories) != len(uniqueIDs(payload.CategoryIDs)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "One or more categories were not found"})
+	}
+
+	if err := db.Model(&product).Omit("Categories.*").Association("Categories").Replace(categories); err != nil {
+		log.Printf("Error updating product categories: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update product categories"})
+	}
+
+	return c.JSON(product)
+}
+
+// validateCategoryParent makes sure moving a category below parentID keeps
+// the tree free of cycles.
+func validateCategoryParent(db *gorm.DB, id, parentID uuid.UUID) error {
+	err := db.First(&models.Category{}, parentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errParentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var descendants []uuid.UUID
+	if err := db.Raw(categoryDescendantsSQL, id).Scan(&descendants).Error; err != nil {
+		return err
+	}
+	for _, descendant := range descendants {
+		if descendant == parentID {
+			return errCategoryCycle
+		}
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err was caused by a unique index.
+func isUniqueViolation(db *gorm.DB, err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+func parseParentID(raw interface{}) (*uuid.UUID, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return nil, errors.New("invalid parent_id: expected UUID")
+	}
+	parentID, err := uuid.Parse(value)
+	if err != nil {
+		return nil, errors.New("invalid parent_id: expected UUID")
+	}
+	return &parentID, nil
+}
+
+// buildCategoryTree nests categories below their parents, starting from the
+// children of parentID (the roots when parentID is nil).
+func buildCategoryTree(categories []models.Category, parentID *uuid.UUID) []models.Category {
+	tree := []models.Category{}
+	for _, category := range categories {
+		if (parentID == nil && category.ParentID == nil) ||
+			(parentID != nil && category.ParentID != nil && *category.ParentID == *parentID) {
+			category.Children = buildCategoryTree(categories, &category.ID)
+			tree = append(tree, category)
+		}
+	}
+	return tree
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
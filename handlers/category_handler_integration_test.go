@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"products/database"
+	"products/models"
+	"testing"
+)
+
+func resetCategories(t *testing.T) {
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM product_categories")
+	database.DB.Exec("DELETE FROM categories")
+	database.DB.Exec("DELETE FROM products")
+}
+
+func createTestCategory(name string, parentID *uuid.UUID) models.Category {
+	category := models.Category{Name: name, ParentID: parentID}
+	database.DB.Create(&category)
+	return category
+}
+
+func sendJSON(t *testing.T, app *fiber.App, method, target, payload string) (int, []byte) {
+	var body io.Reader
+	if payload != "" {
+		body = bytes.NewBufferString(payload)
+	}
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, respBody
+}
+
+func TestCreateCategory(t *testing.T) {
+	app := setupTestApp()
+	resetCategories(t)
+	parent := createTestCategory("Doces", nil)
+
+	testCases := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Root category",
+			payload:        `{"name":"Salgados"}`,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Success - Child category",
+			payload:        fmt.Sprintf(`{"name":"Chocolates","parent_id":"%s"}`, parent.ID),
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Failure - Duplicate name under the same parent",
+			payload:        fmt.Sprintf(`{"name":"Chocolates","parent_id":"%s"}`, parent.ID),
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Failure - Missing name",
+			payload:        `{"name":""}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Unknown parent",
+			payload:        fmt.Sprintf(`{"name":"Balas","parent_id":"%s"}`, uuid.New()),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPost, "/api/categories", tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+}
+
+func TestGetCategoriesTree(t *testing.T) {
+	app := setupTestApp()
+	resetCategories(t)
+	doces := createTestCategory("Doces", nil)
+	createTestCategory("Chocolates", &doces.ID)
+	createTestCategory("Salgados", nil)
+
+	status, body := sendJSON(t, app, http.MethodGet, "/api/categories?tree=true", "")
+	assert.Equal(t, fiber.StatusOK, status)
+
+	var tree []models.Category
+	assert.NoError(t, json.Unmarshal(body, &tree))
+	assert.Len(t, tree, 2)
+	assert.Equal(t, "Doces", tree[0].Name)
+	assert.Len(t, tree[0].Children, 1)
+	assert.Equal(t, "Chocolates", tree[0].Children[0].Name)
+	assert.Empty(t, tree[1].Children)
+
+	status, body = sendJSON(t, app, http.MethodGet, "/api/categories", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var flat []models.Category
+	assert.NoError(t, json.Unmarshal(body, &flat))
+	assert.Len(t, flat, 3)
+}
+
+func TestPatchCategory(t *testing.T) {
+	app := setupTestApp()
+	resetCategories(t)
+	doces := createTestCategory("Doces", nil)
+	chocolates := createTestCategory("Chocolates", &doces.ID)
+	amargos := createTestCategory("Amargos", &chocolates.ID)
+	salgados := createTestCategory("Salgados", nil)
+
+	testCases := []struct {
+		name           string
+		categoryID     uuid.UUID
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Rename",
+			categoryID:     salgados.ID,
+			payload:        `{"name":"Salgados Assados"}`,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Success - Move below another parent",
+			categoryID:     amargos.ID,
+			payload:        fmt.Sprintf(`{"parent_id":"%s"}`, doces.ID),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Failure - Rename to a sibling's name",
+			categoryID:     amargos.ID,
+			payload:        `{"name":"Chocolates"}`,
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Success - Move to root",
+			categoryID:     chocolates.ID,
+			payload:        `{"parent_id":null}`,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Failure - Cycle",
+			categoryID:     doces.ID,
+			payload:        fmt.Sprintf(`{"parent_id":"%s"}`, amargos.ID),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Own parent",
+			categoryID:     doces.ID,
+			payload:        fmt.Sprintf(`{"parent_id":"%s"}`, doces.ID),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Category not found",
+			categoryID:     uuid.New(),
+			payload:        `{"name":"Fantasma"}`,
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPatch, "/api/categories/"+tc.categoryID.String(), tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+
+	var moved models.Category
+	database.DB.First(&moved, chocolates.ID)
+	assert.Nil(t, moved.ParentID)
+}
+
+func TestDeleteCategory(t *testing.T) {
+	app := setupTestApp()
+	resetCategories(t)
+	doces := createTestCategory("Doces", nil)
+	chocolates := createTestCategory("Chocolates", &doces.ID)
+
+	status, _ := sendJSON(t, app, http.MethodDelete, "/api/categories/"+doces.ID.String(), "")
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/categories/"+chocolates.ID.String(), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/categories/"+chocolates.ID.String(), "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestProductCategories(t *testing.T) {
+	app := setupTestApp()
+	resetCategories(t)
+	doces := createTestCategory("Doces", nil)
+	chocolates := createTestCategory("Chocolates", &doces.ID)
+	salgados := createTestCategory("Salgados", nil)
+
+	brigadeiro := models.Product{Name: "Brigadeiro", Price: 300}
+	bombom := models.Product{Name: "Bombom", Price: 500}
+	coxinha := models.Product{Name: "Coxinha", Price: 700}
+	database.DB.Create(&brigadeiro)
+	database.DB.Create(&bombom)
+	database.DB.Create(&coxinha)
+
+	assign := func(product models.Product, categories ...uuid.UUID) int {
+		payload, _ := json.Marshal(ProductCategoriesRequest{CategoryIDs: categories})
+		status, _ := sendJSON(t, app, http.MethodPut, "/api/products/"+product.ID.String()+"/categories", string(payload))
+		return status
+	}
+	assert.Equal(t, fiber.StatusOK, assign(brigadeiro, doces.ID))
+	assert.Equal(t, fiber.StatusOK, assign(bombom, chocolates.ID))
+	assert.Equal(t, fiber.StatusOK, assign(coxinha, salgados.ID))
+	assert.Equal(t, fiber.StatusBadRequest, assign(coxinha, uuid.New()))
+
+	listNames := func(query string) []string {
+		status, body := sendJSON(t, app, http.MethodGet, "/api/products?sort=name&"+query, "")
+		assert.Equal(t, fiber.StatusOK, status)
+		var returned ProductListResponse
+		assert.NoError(t, json.Unmarshal(body, &returned))
+		names := []string{}
+		for _, product := range returned.Data {
+			names = append(names, product.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"Brigadeiro"}, listNames("category_id="+doces.ID.String()))
+	assert.Equal(t, []string{"Bombom", "Brigadeiro"}, listNames("category_id="+doces.ID.String()+"&include_descendants=true"))
+	assert.Equal(t, []string{"Coxinha"}, listNames("category_id="+salgados.ID.String()+"&include_descendants=true"))
+
+	status, body := sendJSON(t, app, http.MethodGet, "/api/products/"+bombom.ID.String(), "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var returned models.Product
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	assert.Len(t, returned.Categories, 1)
+	assert.Equal(t, "Chocolates", returned.Categories[0].Name)
+}
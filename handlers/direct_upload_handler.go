@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"products/cleanup"
+	"products/config"
+	"products/database"
+	"products/imaging"
+	"products/models"
+	"products/signing"
+	"products/storage"
+	"time"
+)
+
+const (
+	defaultUploadTicketTTL       = 15 * time.Minute
+	defaultUploadCompleteTimeout = 2 * time.Minute
+	// uploadClaimSlack keeps a ticket claimed a little past the completion
+	// timeout, for the transaction saving the image.
+	uploadClaimSlack = time.Minute
+)
+
+type ImageUploadTicketResponse struct {
+	Upload storage.UploadTicket `json:"upload"`
+	// Token completes the upload once the file is sent.
+	Token string `json:"token"`
+}
+
+type CompleteImageUploadRequest struct {
+	Token   string  `json:"token"`
+	AltText *string `json:"alt_text,omitempty"`
+	// IsPrimary makes the image the primary image of the product. The first
+	// image of a product is always primary.
+	IsPrimary bool `json:"is_primary,omitempty"`
+}
+
+// imageUploadClaim is the signed payload of an upload token. The public ID
+// to complete is read from the ticket, never from the token.
+type imageUploadClaim struct {
+	TicketID  uuid.UUID `json:"ticket_id"`
+	ProductID uuid.UUID `json:"product_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// errUploadTicketUsed means the ticket of an upload token was already
+// completed, or never issued.
+var errUploadTicketUsed = errors.New("upload ticket already used")
+
+// CreateImageUploadTicket godoc
+// @Summary      Start a direct image upload
+// @Description  Issue a short-lived ticket to upload an image of a product straight to the storage backend, without sending it through the API. Send the file as a multipart form as described by the ticket, then complete the upload with its token before it expires.
+// @Tags         images
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (UUID)"
+// @Success      201  {object}  ImageUploadTicketResponse
+// @Failure      404  {object}  map[string]string
+// @Failure      501  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/uploads [post]
+func CreateImageUploadTicket(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	uploader, ok := storage.Images.(storage.DirectUploader)
+	if !ok {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": storage.ErrDirectUploadUnsupported.Error()})
+	}
+
+	expiresAt := time.Now().Add(config.Duration("IMAGE_UPLOAD_TICKET_TTL", defaultUploadTicketTTL))
+	ticket, err := uploader.SignUpload(c.Context(), storage.ProductImagesFolder, expiresAt)
+	if err != nil {
+		log.Printf("Error signing image upload: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start upload"})
+	}
+	record := models.ImageUploadTicket{
+		ProductID:      productID,
+		StorageBackend: storage.Images.Backend(),
+		PublicID:       ticket.PublicID,
+		ExpiresAt:      expiresAt,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := pruneUploadTickets(tx); err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		log.Printf("Error saving image upload ticket: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start upload"})
+	}
+	token, err := signing.Encode(imageUploadClaim{
+		TicketID:  record.ID,
+		ProductID: productID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error signing image upload: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start upload"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(ImageUploadTicketResponse{Upload: *ticket, Token: token})
+}
+
+// CompleteImageUpload godoc
+// @Summary      Complete a direct image upload
+// @Description  Verify the image uploaded with a ticket and add it to the gallery of the product. The image is validated, stripped of its metadata and resized like any other upload; the uploaded file is then removed.
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                      true  "Product ID (UUID)"
+// @Param        request  body      CompleteImageUploadRequest  true  "Upload token"
+// @Success      201      {object}  models.ProductImage
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      413      {object}  map[string]string
+// @Failure      415      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/uploads/complete [post]
+func CompleteImageUpload(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(CompleteImageUploadRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	var claim imageUploadClaim
+	if err := signing.Decode(payload.Token, &claim); err != nil || claim.ProductID != productID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid upload token"})
+	}
+	if !time.Now().Before(claim.ExpiresAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload token expired"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	// The ticket is claimed before its upload is read, so concurrent
+	// completions of a token cannot both attach its file, and the upload is
+	// processed outside of any transaction. The claim is released when the
+	// upload cannot be completed, and expires if this request never finishes.
+	timeout := config.Duration("IMAGE_UPLOAD_COMPLETE_TIMEOUT", defaultUploadCompleteTimeout)
+	claimedUntil := time.Now().Add(timeout + uploadClaimSlack).Truncate(time.Microsecond)
+	ticket, err := claimUploadTicket(db, claim.TicketID, productID, claimedUntil)
+	if errors.Is(err, errUploadTicketUsed) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload token was already used"})
+	}
+	if err != nil {
+		log.Printf("Error claiming image upload ticket: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save images"})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), timeout)
+	defer cancel()
+	upload, err := storeDirectUpload(ctx, ticket)
+	if err != nil {
+		releaseUploadTicket(db, ticket.ID, claimedUntil)
+		if errors.Is(err, storage.ErrUploadNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Nothing was uploaded with this token"})
+		}
+		return imageUploadError(c, err)
+	}
+	upload.AltText = payload.AltText
+
+	var image models.ProductImage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		deleted := tx.Where("claimed_until = ?", claimedUntil).Delete(&ticket)
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return errUploadTicketUsed
+		}
+		images, err := addProductImages(tx, productID, []models.ProductImage{*upload}, payload.IsPrimary)
+		if err != nil {
+			return err
+		}
+		image = images[0]
+		return nil
+	})
+	if err != nil {
+		discardImages(*upload)
+		if errors.Is(err, errUploadTicketUsed) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload token was already used"})
+		}
+		releaseUploadTicket(db, ticket.ID, claimedUntil)
+		log.Printf("Error saving product image: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save images"})
+	}
+
+	removeDirectUpload(ctx, ticket)
+	return c.Status(fiber.StatusCreated).JSON(image)
+}
+
+// ReceiveDirectUpload godoc
+// @Summary      Receive a direct image upload
+// @Description  Store a file sent to an upload URL issued for the local storage backend. The URL is signed and accepts a single JPEG, PNG or WebP image until it expires; no API key is needed. The file is not served until the upload is completed.
+// @Tags         images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        token  path      string  true  "Signed upload token"
+// @Param        file   formData  file    true  "Product Image"
+// @Success      201    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      403    {object}  map[string]string
+// @Failure      415    {object}  map[string]string
+// @Router       /uploads/{token} [post]
+func ReceiveDirectUpload(c *fiber.Ctx) error {
+	db := database.DB
+	store, err := storage.For(storage.BackendLocal)
+	local, ok := store.(*storage.LocalStore)
+	if err != nil || !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": storage.ErrDirectUploadUnsupported.Error()})
+	}
+	publicID, err := local.VerifyUpload(c.Params("token"))
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image upload failed"})
+	}
+	fileReader, err := file.Open()
+	if err != nil {
+		log.Printf("Error opening uploaded file: %s", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image upload failed"})
+	}
+	defer fileReader.Close()
+
+	// Only images are accepted; they are fully validated on completion.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(fileReader, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		log.Printf("Error reading uploaded file: %s", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image upload failed"})
+	}
+	head = head[:n]
+	if _, err := imaging.Sniff(head); err != nil {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// The ticket records that its URL was used, whatever happens to the file
+	// afterwards.
+	received := db.Model(&models.ImageUploadTicket{}).
+		Where("public_id = ? AND received_at IS NULL", publicID).
+		Update("received_at", time.Now())
+	if received.Error != nil {
+		log.Printf("Error recording direct upload: %s", received.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to upload file"})
+	}
+	if received.RowsAffected == 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": storage.ErrInvalidUploadURL.Error()})
+	}
+
+	if err := local.Receive(c.Context(), publicID, io.MultiReader(bytes.NewReader(head), fileReader)); err != nil {
+		log.Printf("Error receiving direct upload: %s", err)
+		reset := db.Model(&models.ImageUploadTicket{}).Where("public_id = ?", publicID).Update("received_at", nil)
+		if reset.Error != nil {
+			log.Printf("Error releasing direct upload: %s", reset.Error)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to upload file"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"public_id": publicID})
+}
+
+// pruneUploadTickets deletes expired tickets, queueing the deletion of files
+// received for them but never completed.
+func pruneUploadTickets(tx *gorm.DB) error {
+	var expired []models.ImageUploadTicket
+	if err := tx.Where("expires_at < ?", time.Now()).Find(&expired).Error; err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	for _, ticket := range expired {
+		if ticket.ReceivedAt == nil {
+			continue
+		}
+		if err := cleanup.EnqueueFile(tx, ticket.StorageBackend, ticket.PublicID); err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&expired).Error
+}
+
+// claimUploadTicket claims the ticket of an upload token until claimedUntil.
+// Tickets that were completed, never issued, or are claimed by a completion
+// still in progress cannot be claimed.
+func claimUploadTicket(db *gorm.DB, ticketID, productID uuid.UUID, claimedUntil time.Time) (models.ImageUploadTicket, error) {
+	var ticket models.ImageUploadTicket
+	claimed := db.Model(&models.ImageUploadTicket{}).
+		Where("id = ? AND product_id = ?", ticketID, productID).
+		Where("claimed_until IS NULL OR claimed_until < ?", time.Now()).
+		Update("claimed_until", claimedUntil)
+	if claimed.Error != nil {
+		return ticket, claimed.Error
+	}
+	if claimed.RowsAffected == 0 {
+		return ticket, errUploadTicketUsed
+	}
+	err := db.First(&ticket, ticketID).Error
+	return ticket, err
+}
+
+// releaseUploadTicket releases the claim on a ticket whose upload could not be
+// completed, so the token can be completed again. Failures are only logged:
+// the claim then expires on its own.
+func releaseUploadTicket(db *gorm.DB, ticketID uuid.UUID, claimedUntil time.Time) {
+	err := db.Model(&models.ImageUploadTicket{}).
+		Where("id = ? AND claimed_until = ?", ticketID, claimedUntil).
+		Update("claimed_until", nil).Error
+	if err != nil {
+		log.Printf("Error releasing image upload ticket %s: %s", ticketID, err)
+	}
+}
+
+// storeDirectUpload reads the file uploaded with ticket and stores it like an
+// uploaded image. Rejected uploads are removed; accepted ones are kept until
+// the image is saved, so a completion that fails to save can be retried.
+func storeDirectUpload(ctx context.Context, ticket models.ImageUploadTicket) (*models.ProductImage, error) {
+	store, err := storage.For(ticket.StorageBackend)
+	if err != nil {
+		return nil, err
+	}
+	uploader, ok := store.(storage.DirectUploader)
+	if !ok {
+		return nil, storage.ErrDirectUploadUnsupported
+	}
+
+	raw, err := uploader.Open(ctx, ticket.PublicID)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := storeImageFrom(ctx, ticket.PublicID, raw)
+	if err := raw.Close(); err != nil {
+		log.Printf("Failed to close uploaded file: %v", err)
+	}
+	if err != nil {
+		// An upload that timed out was not rejected, and stays to be
+		// completed again.
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if err := store.Delete(ctx, ticket.PublicID); err != nil {
+			log.Printf("Failed to delete rejected upload with public_id %s: %v", ticket.PublicID, err)
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+// removeDirectUpload removes the file uploaded with a completed ticket, or
+// queues its deletion when the backend cannot be reached.
+func removeDirectUpload(ctx context.Context, ticket models.ImageUploadTicket) {
+	store, err := storage.For(ticket.StorageBackend)
+	if err == nil {
+		err = store.Delete(ctx, ticket.PublicID)
+	}
+	if err == nil {
+		return
+	}
+	log.Printf("Failed to delete completed upload with public_id %s: %v", ticket.PublicID, err)
+	if err := cleanup.EnqueueFile(database.DB, ticket.StorageBackend, ticket.PublicID); err != nil {
+		log.Printf("Error queueing the deletion of a completed upload: %s", err)
+	}
+}
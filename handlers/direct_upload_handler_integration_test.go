@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"path/filepath"
+	"products/database"
+	"products/models"
+	"products/signing"
+	"products/storage"
+	"testing"
+	"time"
+)
+
+func TestDirectImageUpload(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "test-secret")
+	app := setupTestApp()
+	product := resetImages(t)
+	store := storage.Images.(*storage.LocalStore)
+	ticketTarget := "/api/products/" + product.ID.String() + "/images/uploads"
+	completeTarget := ticketTarget + "/complete"
+
+	requestTicket := func() ImageUploadTicketResponse {
+		status, body := sendJSON(t, app, http.MethodPost, ticketTarget, "")
+		assert.Equal(t, fiber.StatusCreated, status)
+		var ticket ImageUploadTicketResponse
+		assert.NoError(t, json.Unmarshal(body, &ticket))
+		return ticket
+	}
+
+	status, _ := sendJSON(t, app, http.MethodPost, "/api/products/"+uuid.NewString()+"/images/uploads", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	ticket := requestTicket()
+	assert.Equal(t, http.MethodPost, ticket.Upload.Method)
+	assert.Equal(t, "file", ticket.Upload.FileField)
+
+	// Completing before anything was uploaded fails.
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", testPNG(t, 8, 8))
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(ticket.Upload.PublicID)), "uploads are not served before they are completed")
+	assert.FileExists(t, filepath.Join(store.StagingDir, filepath.FromSlash(ticket.Upload.PublicID)))
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", testPNG(t, 8, 8))
+	assert.Equal(t, fiber.StatusForbidden, status, "an upload URL is used once")
+	status, _ = uploadFiles(t, app, ticket.Upload.URL+"x", "file", testPNG(t, 8, 8))
+	assert.Equal(t, fiber.StatusForbidden, status)
+
+	other := models.Product{Name: "Bacuri", Price: 900}
+	database.DB.Create(&other)
+	status, _ = sendJSON(t, app, http.MethodPost, "/api/products/"+other.ID.String()+"/images/uploads/complete", fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusBadRequest, status, "tokens are bound to their product")
+
+	status, body := sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s","alt_text":"Cupuaçu"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusCreated, status)
+	var image models.ProductImage
+	assert.NoError(t, json.Unmarshal(body, &image))
+	assert.True(t, image.IsPrimary)
+	assert.Equal(t, "Cupuaçu", *image.AltText)
+	assert.Regexp(t, `\.png$`, image.PublicID)
+	assert.FileExists(t, filepath.Join(store.Dir, filepath.FromSlash(image.PublicID)))
+	assert.NoFileExists(t, filepath.Join(store.StagingDir, filepath.FromSlash(ticket.Upload.PublicID)), "the uploaded file is replaced by the stored image")
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", testPNG(t, 8, 8))
+	assert.Equal(t, fiber.StatusForbidden, status, "the URL of a completed upload cannot be used again")
+
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusBadRequest, status, "a token is completed once")
+
+	// A ticket claimed by a completion in progress cannot be completed, but
+	// the claim of a completion that never finished expires.
+	ticket = requestTicket()
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", testPNG(t, 8, 8))
+	assert.Equal(t, fiber.StatusCreated, status)
+	claimed := database.DB.Model(&models.ImageUploadTicket{}).Where("public_id = ?", ticket.Upload.PublicID)
+	assert.NoError(t, claimed.Update("claimed_until", time.Now().Add(time.Minute)).Error)
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusBadRequest, status, "a claimed ticket is not completed twice")
+	claimed = database.DB.Model(&models.ImageUploadTicket{}).Where("public_id = ?", ticket.Upload.PublicID)
+	assert.NoError(t, claimed.Update("claimed_until", time.Now().Add(-time.Minute)).Error)
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusCreated, status, "an expired claim is taken over")
+
+	// Only images are received, and they are validated like any other
+	// upload on completion.
+	ticket = requestTicket()
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", []byte("<html><script>alert(1)</script></html>"))
+	assert.Equal(t, fiber.StatusUnsupportedMediaType, status)
+	status, _ = uploadFiles(t, app, ticket.Upload.URL, "file", append(testPNG(t, 8, 8)[:16], "truncada"...))
+	assert.Equal(t, fiber.StatusCreated, status, "a rejected file does not use the upload URL")
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, ticket.Token))
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	var rejected models.ImageUploadTicket
+	assert.NoError(t, database.DB.Where("public_id = ?", ticket.Upload.PublicID).First(&rejected).Error)
+	assert.Nil(t, rejected.ClaimedUntil, "the claim of a failed completion is released")
+
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, `{"token":"invalido"}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	// Only tickets that were issued can be completed, even with a token
+	// signed with the right secret.
+	forged, err := signing.Encode(imageUploadClaim{TicketID: uuid.New(), ProductID: product.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	status, _ = sendJSON(t, app, http.MethodPost, completeTarget, fmt.Sprintf(`{"token":"%s"}`, forged))
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	var tickets int64
+	database.DB.Model(&models.ImageUploadTicket{}).Where("product_id = ?", product.ID).Count(&tickets)
+	assert.Equal(t, int64(1), tickets, "completed tickets are consumed")
+}
@@ -0,0 +1,693 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"log"
+	"mime/multipart"
+	"products/cleanup"
+	"products/config"
+	"products/database"
+	"products/imaging"
+	"products/inventory"
+	"products/models"
+	"products/remote"
+	"products/storage"
+	"time"
+)
+
+const (
+	// maxImagesPerUpload bounds how many files a single gallery upload accepts.
+	maxImagesPerUpload = 10
+
+	defaultImageFetchTimeout = 10 * time.Second
+)
+
+// imageSourceAllowed decides which addresses images may be imported from.
+var imageSourceAllowed = remote.IsPublic
+
+type PatchImageRequest struct {
+	AltText *string `json:"alt_text,omitempty"`
+	// IsPrimary makes the image the primary image of the product when true.
+	IsPrimary *bool `json:"is_primary,omitempty"`
+}
+
+type ImportImageRequest struct {
+	URL     string  `json:"url"`
+	AltText *string `json:"alt_text,omitempty"`
+	// IsPrimary makes the image the primary image of the product. The first
+	// image of a product is always primary.
+	IsPrimary bool `json:"is_primary,omitempty"`
+}
+
+type ReorderImagesRequest struct {
+	ImageIDs []uuid.UUID `json:"image_ids"`
+}
+
+var (
+	errImageNotFound   = errors.New("image not found")
+	errInvalidOrdering = errors.New("image_ids must list every image of the product exactly once")
+)
+
+// UploadProductImages godoc
+// @Summary      Add images to a Product
+// @Description  Upload one or more images to the gallery of a product. They are added after the existing images; the first image of a product becomes its primary image. Only JPEG, PNG and WebP images within the configured size and dimensions are accepted, and their metadata is removed.
+// @Tags         images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        id      path      string  true  "Product ID (UUID)"
+// @Param        images  formData  file    true  "Product Images"
+// @Success      201     {array}   models.ProductImage
+// @Failure      400     {object}  map[string]string
+// @Failure      404     {object}  map[string]string
+// @Failure      413     {object}  map[string]string
+// @Failure      415     {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images [post]
+func UploadProductImages(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		log.Printf("Error uploading product images: %s", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image upload failed"})
+	}
+	files := append(form.File["images"], form.File["image"]...)
+	if len(files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one image is required"})
+	}
+	if len(files) > maxImagesPerUpload {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Too many images in a single upload"})
+	}
+
+	uploads := make([]models.ProductImage, 0, len(files))
+	for _, file := range files {
+		upload, err := storeImage(file)
+		if err != nil {
+			discardImages(uploads...)
+			return imageUploadError(c, err)
+		}
+		uploads = append(uploads, *upload)
+	}
+
+	images, err := addProductImages(db, productID, uploads, false)
+	if err != nil {
+		log.Printf("Error saving product images: %s", err)
+		discardImages(uploads...)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save images"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(images)
+}
+
+// ImportProductImage godoc
+// @Summary      Import an image of a Product from a URL
+// @Description  Download an image from a public http or https URL and add it to the gallery of a product, just like an uploaded image. Addresses in private or reserved ranges are refused. The download is bounded by IMAGE_MAX_BYTES and IMAGE_FETCH_TIMEOUT.
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id     path      string              true  "Product ID (UUID)"
+// @Param        image  body      ImportImageRequest  true  "Image source"
+// @Success      201    {object}  models.ProductImage
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Failure      413    {object}  map[string]string
+// @Failure      415    {object}  map[string]string
+// @Failure      502    {object}  map[string]string
+// @Failure      504    {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/import [post]
+func ImportProductImage(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(ImportImageRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if payload.URL == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image url is required"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	data, err := imageFetcher().Fetch(c.Context(), payload.URL)
+	if err != nil {
+		return imageFetchError(c, err)
+	}
+	upload, err := storeImageFrom(context.Background(), payload.URL, bytes.NewReader(data))
+	if err != nil {
+		return imageUploadError(c, err)
+	}
+	upload.AltText = payload.AltText
+
+	images, err := addProductImages(db, productID, []models.ProductImage{*upload}, payload.IsPrimary)
+	if err != nil {
+		log.Printf("Error saving product image: %s", err)
+		discardImages(*upload)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save images"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(images[0])
+}
+
+// GetProductImages godoc
+// @Summary      List the images of a Product
+// @Description  Return the gallery of a product in display order
+// @Tags         images
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (UUID)"
+// @Success      200  {array}   models.ProductImage
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /products/{id}/images [get]
+func GetProductImages(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	images := []models.ProductImage{}
+	if err := orderedImages(db).Where("product_id = ?", productID).Find(&images).Error; err != nil {
+		log.Printf("Error getting product images in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch images"})
+	}
+	return c.JSON(images)
+}
+
+// ReorderProductImages godoc
+// @Summary      Reorder the images of a Product
+// @Description  Set the display order of the gallery. image_ids must list every image of the product exactly once.
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                true  "Product ID (UUID)"
+// @Param        request  body      ReorderImagesRequest  true  "Image IDs in display order"
+// @Success      200      {array}   models.ProductImage
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/order [put]
+func ReorderProductImages(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(ReorderImagesRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	images := []models.ProductImage{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+
+		var existing []uuid.UUID
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", productID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		if len(uniqueIDs(payload.ImageIDs)) != len(payload.ImageIDs) || len(payload.ImageIDs) != len(existing) {
+			return errInvalidOrdering
+		}
+		known := make(map[uuid.UUID]bool, len(existing))
+		for _, id := range existing {
+			known[id] = true
+		}
+		for position, id := range payload.ImageIDs {
+			if !known[id] {
+				return errInvalidOrdering
+			}
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+
+		return orderedImages(tx).Where("product_id = ?", productID).Find(&images).Error
+	})
+	if err != nil {
+		return imageError(c, err)
+	}
+	return c.JSON(images)
+}
+
+// PatchProductImage godoc
+// @Summary      Update an image of a Product
+// @Description  Change the alt text of an image, or make it the primary image of the product. The product image_url follows the primary image.
+// @Tags         images
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string             true  "Product ID (UUID)"
+// @Param        imageId  path      string             true  "Image ID (UUID)"
+// @Param        image    body      PatchImageRequest  true  "New Image Data"
+// @Success      200      {object}  models.ProductImage
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/{imageId} [patch]
+func PatchProductImage(c *fiber.Ctx) error {
+	db := database.DB
+	productID, imageID, err := parseImageParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	payload := new(PatchImageRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if payload.IsPrimary != nil && !*payload.IsPrimary {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Set another image as primary instead"})
+	}
+
+	var image models.ProductImage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+		if err := findProductImage(tx, productID, imageID, &image); err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if payload.AltText != nil {
+			if *payload.AltText == "" {
+				updates["alt_text"] = nil
+			} else {
+				updates["alt_text"] = *payload.AltText
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", image.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if payload.IsPrimary != nil {
+			if err := setPrimaryImage(tx, productID, image.ID); err != nil {
+				return err
+			}
+		}
+
+		return tx.First(&image, image.ID).Error
+	})
+	if err != nil {
+		return imageError(c, err)
+	}
+	return c.JSON(image)
+}
+
+// DeleteProductImage godoc
+// @Summary      Delete an image of a Product
+// @Description  Remove an image from the gallery and from the storage backend. When the primary image is removed, the next image in order becomes primary.
+// @Tags         images
+// @Param        id       path      string  true  "Product ID (UUID)"
+// @Param        imageId  path      string  true  "Image ID (UUID)"
+// @Success      204      {object}  nil
+// @Failure      404      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/images/{imageId} [delete]
+func DeleteProductImage(c *fiber.Ctx) error {
+	db := database.DB
+	productID, imageID, err := parseImageParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var image models.ProductImage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+		if err := findProductImage(tx, productID, imageID, &image); err != nil {
+			return err
+		}
+		return removeProductImage(tx, image)
+	})
+	if err != nil {
+		return imageError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImageBodyLimit is the request body size needed to upload a single image at
+// the configured maximum size.
+func ImageBodyLimit() int {
+	return int(imaging.DefaultLimits().MaxBytes) + 1<<20
+}
+
+// UploadBodyLimit is the request body size needed to upload a full gallery
+// batch of images at the configured maximum size.
+func UploadBodyLimit() int {
+	return int(imaging.DefaultLimits().MaxBytes)*maxImagesPerUpload + 1<<20
+}
+
+// storeImage validates a file of a multipart form, strips its metadata and
+// uploads it to the image storage along with its renditions. The returned
+// image is not saved yet.
+func storeImage(file *multipart.FileHeader) (*models.ProductImage, error) {
+	fileReader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := fileReader.Close(); err != nil {
+			log.Printf("Failed to close file reader: %v", err)
+		}
+	}()
+	return storeImageFrom(context.Background(), file.Filename, fileReader)
+}
+
+// storeImageFrom is storeImage for an image read from r, named name in
+// errors.
+func storeImageFrom(ctx context.Context, name string, r io.Reader) (*models.ProductImage, error) {
+	normalized, err := imaging.Normalize(r, imaging.DefaultLimits())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	renditions, err := imaging.Renditions(normalized, imaging.Sizes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, imaging.ErrInvalidImage, err)
+	}
+
+	original, err := storage.Images.Upload(ctx, bytes.NewReader(normalized.Data), storage.ProductImagesFolder)
+	if err != nil {
+		return nil, err
+	}
+	stored := &models.ProductImage{
+		URL:            original.URL,
+		PublicID:       original.PublicID,
+		StorageBackend: storage.Images.Backend(),
+		Renditions:     make(map[string]models.Rendition, len(imaging.Sizes)),
+	}
+
+	for _, size := range imaging.Sizes {
+		rendition, ok := renditions[size.Name]
+		if !ok {
+			stored.Renditions[size.Name] = models.Rendition{URL: original.URL, Width: normalized.Width, Height: normalized.Height}
+			continue
+		}
+		upload, err := storage.Images.Upload(ctx, bytes.NewReader(rendition.Data), storage.ProductImagesFolder)
+		if err != nil {
+			discardImages(*stored)
+			return nil, err
+		}
+		stored.Renditions[size.Name] = models.Rendition{
+			URL:      upload.URL,
+			PublicID: upload.PublicID,
+			Width:    rendition.Width,
+			Height:   rendition.Height,
+		}
+	}
+	return stored, nil
+}
+
+// imageUploadError responds to a failed upload: unsupported content is 415,
+// images over the size or dimension limits are 413.
+func imageUploadError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, imaging.ErrTooLarge), errors.Is(err, imaging.ErrDimensionsTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, imaging.ErrInvalidImage):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("Error storing product image: %s", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to upload file"})
+}
+
+// imageFetcher downloads imported images within the upload size limit and
+// IMAGE_FETCH_TIMEOUT.
+func imageFetcher() *remote.Fetcher {
+	return &remote.Fetcher{
+		Timeout:  config.Duration("IMAGE_FETCH_TIMEOUT", defaultImageFetchTimeout),
+		MaxBytes: imaging.DefaultLimits().MaxBytes,
+		Allow:    imageSourceAllowed,
+	}
+}
+
+// imageFetchError responds to a failed image download: refused URLs are 400,
+// failures of the remote server are 502, or 504 when it was too slow.
+func imageFetchError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, remote.ErrInvalidURL), errors.Is(err, remote.ErrBlockedAddress):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, remote.ErrTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, context.DeadlineExceeded):
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": "Timed out fetching the image"})
+	}
+	log.Printf("Error fetching product image: %s", err)
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not fetch the image"})
+}
+
+// addProductImages appends stored images to the gallery of a product. They
+// become primary when primary is set, or when the product has no primary
+// image yet.
+func addProductImages(db *gorm.DB, productID uuid.UUID, uploads []models.ProductImage, primary bool) ([]models.ProductImage, error) {
+	images := make([]models.ProductImage, 0, len(uploads))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+
+		var next int
+		err := tx.Model(&models.ProductImage{}).
+			Where("product_id = ?", productID).
+			Select("COALESCE(MAX(position) + 1, 0)").
+			Scan(&next).Error
+		if err != nil {
+			return err
+		}
+
+		for i, upload := range uploads {
+			upload.ProductID = productID
+			upload.Position = next + i
+			images = append(images, upload)
+		}
+		if err := tx.Create(&images).Error; err != nil {
+			return err
+		}
+
+		if !primary {
+			var primaries int64
+			err := tx.Model(&models.ProductImage{}).
+				Where("product_id = ? AND is_primary = ?", productID, true).
+				Count(&primaries).Error
+			if err != nil {
+				return err
+			}
+			primary = primaries == 0
+		}
+		if !primary {
+			return nil
+		}
+		images[0].IsPrimary = true
+		return setPrimaryImage(tx, productID, images[0].ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// replacePrimaryImage saves a stored image as the primary image of a product
+// in place of the current one, which takes its position in the gallery. The
+// files of the replaced image are queued for deletion.
+func replacePrimaryImage(db *gorm.DB, productID uuid.UUID, upload models.ProductImage) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
+		}
+
+		var current models.ProductImage
+		err := tx.Where("product_id = ? AND is_primary = ?", productID, true).First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := enqueueLegacyImage(tx, productID); err != nil {
+				return err
+			}
+			_, err = addProductImages(tx, productID, []models.ProductImage{upload}, true)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&current).Error; err != nil {
+			return err
+		}
+		if err := cleanup.EnqueueImages(tx, current); err != nil {
+			return err
+		}
+		upload.ProductID = productID
+		upload.Position = current.Position
+		if err := tx.Create(&upload).Error; err != nil {
+			return err
+		}
+		return setPrimaryImage(tx, productID, upload.ID)
+	})
+}
+
+// enqueueLegacyImage queues the deletion of the image of a product uploaded
+// before galleries existed, which is only referenced by its image_url.
+func enqueueLegacyImage(tx *gorm.DB, productID uuid.UUID) error {
+	var product models.Product
+	if err := tx.Select("image_url").First(&product, productID).Error; err != nil {
+		return err
+	}
+	if product.ImageURL == nil || *product.ImageURL == "" {
+		return nil
+	}
+	var referenced int64
+	err := tx.Model(&models.ProductImage{}).Where("product_id = ? AND url = ?", productID, *product.ImageURL).Count(&referenced).Error
+	if err != nil || referenced > 0 {
+		return err
+	}
+	return cleanup.EnqueueURL(tx, *product.ImageURL)
+}
+
+// removeProductImage deletes an image from the gallery of a locked product
+// and queues the deletion of its files. When it was the primary image, the
+// next image in display order takes its place.
+func removeProductImage(tx *gorm.DB, image models.ProductImage) error {
+	if err := tx.Delete(&image).Error; err != nil {
+		return err
+	}
+	if err := cleanup.EnqueueImages(tx, image); err != nil {
+		return err
+	}
+	if !image.IsPrimary {
+		return nil
+	}
+
+	var next models.ProductImage
+	err := orderedImages(tx).Where("product_id = ?", image.ProductID).First(&next).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return syncPrimaryImage(tx, image.ProductID)
+	}
+	if err != nil {
+		return err
+	}
+	return setPrimaryImage(tx, image.ProductID, next.ID)
+}
+
+// setPrimaryImage makes imageID the only primary image of a product and
+// copies its URL to the product.
+func setPrimaryImage(tx *gorm.DB, productID, imageID uuid.UUID) error {
+	err := tx.Model(&models.ProductImage{}).
+		Where("product_id = ?", productID).
+		Update("is_primary", gorm.Expr("id = ?", imageID)).Error
+	if err != nil {
+		return err
+	}
+	return syncPrimaryImage(tx, productID)
+}
+
+// syncPrimaryImage copies the URLs of the primary image of a product to its
+// image_url and image_sizes, clearing them when the product has no images
+// left.
+func syncPrimaryImage(tx *gorm.DB, productID uuid.UUID) error {
+	var image models.ProductImage
+	err := tx.Where("product_id = ? AND is_primary = ?", productID, true).First(&image).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Model(&models.Product{}).Where("id = ?", productID).
+			Updates(map[string]interface{}{"image_url": nil, "image_sizes": nil}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	sizes := map[string]string{"original": image.URL}
+	for name, rendition := range image.Renditions {
+		sizes[name] = rendition.URL
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).
+		Select("image_url", "image_sizes").
+		Updates(&models.Product{ImageURL: &image.URL, ImageSizes: sizes}).Error
+}
+
+// discardImages queues the deletion of stored images that could not be
+// saved.
+func discardImages(images ...models.ProductImage) {
+	if err := cleanup.EnqueueImages(database.DB, images...); err != nil {
+		log.Printf("Error queueing the deletion of unsaved images: %s", err)
+	}
+}
+
+func orderedImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC").Order("created_at ASC")
+}
+
+// lockProduct locks a product row so concurrent changes to its gallery are
+// serialized.
+func lockProduct(tx *gorm.DB, productID uuid.UUID) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.Product{}, productID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return inventory.ErrProductNotFound
+	}
+	return err
+}
+
+func findProductImage(tx *gorm.DB, productID, imageID uuid.UUID, image *models.ProductImage) error {
+	err := tx.Where("product_id = ?", productID).First(image, imageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errImageNotFound
+	}
+	return err
+}
+
+func parseImageParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, error) {
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	imageID, err := uuid.Parse(c.Params("imageId"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	return productID, imageID, nil
+}
+
+func imageError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrProductNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	case errors.Is(err, errImageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Image not found"})
+	case errors.Is(err, errInvalidOrdering):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("Error updating product images: %s", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update images"})
+}
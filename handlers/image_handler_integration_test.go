@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"products/cleanup"
+	"products/database"
+	"products/models"
+	"products/storage"
+	"testing"
+	"time"
+)
+
+func resetImages(t *testing.T) models.Product {
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM image_deletion_jobs")
+	database.DB.Exec("DELETE FROM product_images")
+	database.DB.Exec("DELETE FROM products")
+
+	product := models.Product{Name: "Cupuaçu", Price: 1200}
+	database.DB.Create(&product)
+	return product
+}
+
+func addImages(t *testing.T, app *fiber.App, product models.Product, count int) []models.ProductImage {
+	contents := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		contents = append(contents, testPNG(t, i+1, i+1))
+	}
+	status, body := uploadImage(t, app, "/api/products/"+product.ID.String()+"/images", contents...)
+	assert.Equal(t, fiber.StatusCreated, status)
+	var images []models.ProductImage
+	assert.NoError(t, json.Unmarshal(body, &images))
+	return images
+}
+
+// processImageDeletions deletes the queued image files right away, as the
+// worker would.
+func processImageDeletions(t *testing.T) {
+	_, err := cleanup.Process(context.Background(), database.DB, time.Now(), 100)
+	assert.NoError(t, err)
+}
+
+func imageURLOf(productID uuid.UUID) *string {
+	var product models.Product
+	database.DB.First(&product, productID)
+	return product.ImageURL
+}
+
+func TestUploadProductImages(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+
+	images := addImages(t, app, product, 2)
+	assert.Len(t, images, 2)
+	assert.True(t, images[0].IsPrimary)
+	assert.False(t, images[1].IsPrimary)
+	assert.Equal(t, []int{0, 1}, []int{images[0].Position, images[1].Position})
+	assert.Equal(t, images[0].URL, *imageURLOf(product.ID))
+	assert.Equal(t, storage.BackendLocal, images[0].StorageBackend)
+
+	more := addImages(t, app, product, 1)
+	assert.False(t, more[0].IsPrimary, "the primary image is kept")
+	assert.Equal(t, 2, more[0].Position)
+
+	// The legacy upload endpoint replaces the primary image in place.
+	status, body := uploadImage(t, app, "/api/products/"+product.ID.String()+"/upload", testPNG(t, 4, 4))
+	assert.Equal(t, fiber.StatusOK, status)
+	var returned models.Product
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	assert.Len(t, returned.Images, 3)
+	assert.True(t, returned.Images[0].IsPrimary)
+	assert.NotEqual(t, images[0].ID, returned.Images[0].ID)
+	assert.Equal(t, images[1].ID, returned.Images[1].ID)
+	assert.Equal(t, returned.Images[0].URL, *returned.ImageURL)
+
+	status, _ = uploadImage(t, app, "/api/products/"+product.ID.String()+"/images")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+	status, _ = uploadImage(t, app, "/api/products/"+uuid.NewString()+"/images", testPNG(t, 1, 1))
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestReorderProductImages(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	images := addImages(t, app, product, 3)
+	target := "/api/products/" + product.ID.String() + "/images/order"
+
+	testCases := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Reverse the gallery",
+			payload:        fmt.Sprintf(`{"image_ids":["%s","%s","%s"]}`, images[2].ID, images[1].ID, images[0].ID),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Failure - Missing image",
+			payload:        fmt.Sprintf(`{"image_ids":["%s","%s"]}`, images[0].ID, images[1].ID),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Duplicated image",
+			payload:        fmt.Sprintf(`{"image_ids":["%s","%s","%s"]}`, images[0].ID, images[0].ID, images[1].ID),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Unknown image",
+			payload:        fmt.Sprintf(`{"image_ids":["%s","%s","%s"]}`, images[0].ID, images[1].ID, uuid.New()),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPut, target, tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+
+	status, body := sendJSON(t, app, http.MethodGet, "/api/products/"+product.ID.String()+"/images", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var ordered []models.ProductImage
+	assert.NoError(t, json.Unmarshal(body, &ordered))
+	assert.Equal(t, []uuid.UUID{images[2].ID, images[1].ID, images[0].ID}, []uuid.UUID{ordered[0].ID, ordered[1].ID, ordered[2].ID})
+	assert.True(t, ordered[2].IsPrimary, "reordering keeps the primary image")
+}
+
+func TestPatchAndDeleteProductImage(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	images := addImages(t, app, product, 3)
+	imageTarget := func(image models.ProductImage) string {
+		return fmt.Sprintf("/api/products/%s/images/%s", product.ID, image.ID)
+	}
+
+	status, body := sendJSON(t, app, http.MethodPatch, imageTarget(images[1]), `{"alt_text":"Polpa de cupuaçu","is_primary":true}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	var patched models.ProductImage
+	assert.NoError(t, json.Unmarshal(body, &patched))
+	assert.True(t, patched.IsPrimary)
+	assert.Equal(t, "Polpa de cupuaçu", *patched.AltText)
+	assert.Equal(t, images[1].URL, *imageURLOf(product.ID))
+
+	status, _ = sendJSON(t, app, http.MethodPatch, imageTarget(images[0]), `{"is_primary":false}`)
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	// Deleting the primary image promotes the next one and removes the file.
+	store := storage.Images.(*storage.LocalStore)
+	stored := filepath.Join(store.Dir, filepath.FromSlash(images[1].PublicID))
+	assert.FileExists(t, stored)
+	status, _ = sendJSON(t, app, http.MethodDelete, imageTarget(images[1]), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	processImageDeletions(t)
+	assert.NoFileExists(t, stored)
+	assert.Equal(t, images[0].URL, *imageURLOf(product.ID))
+
+	status, _ = sendJSON(t, app, http.MethodDelete, imageTarget(images[1]), "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	sendJSON(t, app, http.MethodDelete, imageTarget(images[0]), "")
+	sendJSON(t, app, http.MethodDelete, imageTarget(images[2]), "")
+	assert.Nil(t, imageURLOf(product.ID), "image_url is cleared with the last image")
+}
+
+func TestUploadProductImageValidation(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	t.Setenv("IMAGE_MAX_WIDTH", "64")
+	t.Setenv("IMAGE_MAX_HEIGHT", "64")
+	t.Setenv("IMAGE_MAX_BYTES", "4096")
+
+	testCases := []struct {
+		name           string
+		target         string
+		content        []byte
+		expectedStatus int
+	}{
+		{
+			name:           "Failure - PDF",
+			target:         "/images",
+			content:        []byte("%PDF-1.7\n%âãÏÓ\n1 0 obj\n"),
+			expectedStatus: fiber.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Failure - Dimensions above the limit",
+			target:         "/images",
+			content:        testPNG(t, 65, 10),
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Failure - Size above the limit",
+			target:         "/upload",
+			content:        append(testPNG(t, 1, 1), make([]byte, 4096)...),
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "Failure - Corrupted image",
+			target:         "/upload",
+			content:        testPNG(t, 8, 8)[:30],
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Success - Within the limits",
+			target:         "/images",
+			content:        testPNG(t, 64, 64),
+			expectedStatus: fiber.StatusCreated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := uploadImage(t, app, "/api/products/"+product.ID.String()+tc.target, tc.content)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+
+	var stored int64
+	database.DB.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Count(&stored)
+	assert.Equal(t, int64(1), stored, "rejected images are not stored")
+}
+
+func TestProductImageRenditions(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	store := storage.Images.(*storage.LocalStore)
+
+	status, body := uploadImage(t, app, "/api/products/"+product.ID.String()+"/images", testPNG(t, 600, 300))
+	assert.Equal(t, fiber.StatusCreated, status)
+	var images []models.ProductImage
+	assert.NoError(t, json.Unmarshal(body, &images))
+	if !assert.Len(t, images, 1) {
+		return
+	}
+	image := images[0]
+
+	assert.Equal(t, models.Rendition{URL: image.URL, Width: 600, Height: 300}, image.Renditions["large"])
+	assert.Equal(t, 400, image.Renditions["small"].Width)
+	assert.Equal(t, 75, image.Renditions["thumbnail"].Height)
+	for _, name := range []string{"thumbnail", "small"} {
+		assert.NotEqual(t, image.URL, image.Renditions[name].URL)
+		assert.FileExists(t, filepath.Join(store.Dir, filepath.FromSlash(image.Renditions[name].PublicID)))
+	}
+
+	status, body = sendJSON(t, app, http.MethodGet, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var returned models.Product
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	assert.Equal(t, map[string]string{
+		"original":  image.URL,
+		"thumbnail": image.Renditions["thumbnail"].URL,
+		"small":     image.Renditions["small"].URL,
+		"large":     image.URL,
+	}, returned.ImageSizes)
+
+	status, _ = sendJSON(t, app, http.MethodDelete, fmt.Sprintf("/api/products/%s/images/%s", product.ID, image.ID), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	processImageDeletions(t)
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(image.Renditions["thumbnail"].PublicID)))
+
+	var cleared models.Product
+	database.DB.First(&cleared, product.ID)
+	assert.Nil(t, cleared.ImageSizes)
+}
+
+func TestImportProductImage(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tucuma.png":
+			w.Write(testPNG(t, 8, 8))
+		case "/tucuma.txt":
+			w.Write([]byte("não é uma imagem"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer source.Close()
+	target := "/api/products/" + product.ID.String() + "/images/import"
+
+	// Local servers are refused unless explicitly allowed.
+	status, _ := sendJSON(t, app, http.MethodPost, target, fmt.Sprintf(`{"url":"%s/tucuma.png"}`, source.URL))
+	assert.Equal(t, fiber.StatusBadRequest, status)
+
+	defer func(allowed func(netip.Addr) bool) { imageSourceAllowed = allowed }(imageSourceAllowed)
+	imageSourceAllowed = func(addr netip.Addr) bool { return addr.IsLoopback() }
+
+	testCases := []struct {
+		name           string
+		productID      string
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Import image",
+			productID:      product.ID.String(),
+			payload:        fmt.Sprintf(`{"url":"%s/tucuma.png","alt_text":"Tucumã"}`, source.URL),
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Failure - Not an image",
+			productID:      product.ID.String(),
+			payload:        fmt.Sprintf(`{"url":"%s/tucuma.txt"}`, source.URL),
+			expectedStatus: fiber.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "Failure - Remote error",
+			productID:      product.ID.String(),
+			payload:        fmt.Sprintf(`{"url":"%s/missing.png"}`, source.URL),
+			expectedStatus: fiber.StatusBadGateway,
+		},
+		{
+			name:           "Failure - Invalid scheme",
+			productID:      product.ID.String(),
+			payload:        `{"url":"file:///etc/passwd"}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Missing URL",
+			productID:      product.ID.String(),
+			payload:        `{}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Product not found",
+			productID:      uuid.NewString(),
+			payload:        fmt.Sprintf(`{"url":"%s/tucuma.png"}`, source.URL),
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPost, "/api/products/"+tc.productID+"/images/import", tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+
+	var images []models.ProductImage
+	database.DB.Where("product_id = ?", product.ID).Find(&images)
+	if assert.Len(t, images, 1) {
+		assert.True(t, images[0].IsPrimary)
+		assert.Equal(t, "Tucumã", *images[0].AltText)
+		store := storage.Images.(*storage.LocalStore)
+		assert.FileExists(t, filepath.Join(store.Dir, filepath.FromSlash(images[0].PublicID)))
+	}
+}
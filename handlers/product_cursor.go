@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"net/url"
+	"products/database"
+	"products/models"
+	"products/signing"
+	"time"
+)
+
+const (
+	cursorByCreation = "created"
+	cursorByUpdate   = "updated"
+)
+
+// productCursor is the position of a keyset pagination walk. It is handed to
+// clients as a signed, opaque token so it cannot be forged or tampered with.
+type productCursor struct {
+	Mode string    `json:"m"`
+	At   time.Time `json:"t"`
+	ID   uuid.UUID `json:"i"`
+}
+
+type CursorLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+}
+
+type ProductCursorResponse struct {
+	Data       []models.Product `json:"data"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	HasMore    bool             `json:"has_more"`
+	Limit      int              `json:"limit"`
+	Links      CursorLinks      `json:"links"`
+}
+
+// parseProductCursor returns the cursor a listing request walks from, or nil
+// when the request uses offset pagination. A walk is started with
+// pagination=cursor (whole catalog by creation date) or updated_since (changes
+// since a timestamp) and continued with the cursor returned by the last page.
+func parseProductCursor(c *fiber.Ctx) (*productCursor, error) {
+	if token := c.Query("cursor"); token != "" {
+		cursor := new(productCursor)
+		if err := signing.Decode(token, cursor); err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		if cursor.Mode != cursorByCreation && cursor.Mode != cursorByUpdate {
+			return nil, errors.New("invalid cursor")
+		}
+		return cursor, nil
+	}
+
+	if raw := c.Query("updated_since"); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, errors.New("invalid updated_since: expected RFC3339 timestamp")
+		}
+		return &productCursor{Mode: cursorByUpdate, At: since}, nil
+	}
+
+	switch c.Query("pagination") {
+	case "", "offset":
+		return nil, nil
+	case "cursor":
+		return &productCursor{Mode: cursorByCreation}, nil
+	default:
+		return nil, errors.New("invalid pagination: expected offset or cursor")
+	}
+}
+
+func (cursor *productCursor) column() string {
+	if cursor.Mode == cursorByUpdate {
+		return "updated_at"
+	}
+	return "created_at"
+}
+
+// Seek orders a products query by the cursor column and id, and skips every
+// row up to and including the cursor position.
+func (cursor *productCursor) Seek(db *gorm.DB) *gorm.DB {
+	column := cursor.column()
+	if !cursor.At.IsZero() {
+		db = db.Where(
+			fmt.Sprintf("%[1]s > ? OR (%[1]s = ? AND id > ?)", column),
+			cursor.At, cursor.At, cursor.ID,
+		)
+	}
+	return db.Order(column + " ASC").Order("id ASC")
+}
+
+// After returns the cursor positioned right after product.
+func (cursor *productCursor) After(product models.Product) *productCursor {
+	next := &productCursor{Mode: cursor.Mode, At: product.CreatedAt, ID: product.ID}
+	if cursor.Mode == cursorByUpdate {
+		next.At = product.UpdatedAt
+	}
+	return next
+}
+
+// cursorURL rebuilds the current request URL pointing at the given cursor.
+func cursorURL(c *fiber.Ctx, token string) string {
+	values := url.Values{}
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		values.Add(string(key), string(value))
+	})
+	values.Del("pagination")
+	values.Del("updated_since")
+	values.Set("cursor", token)
+	return c.BaseURL() + c.Path() + "?" + values.Encode()
+}
+
+// listProductsByCursor serves a keyset-paginated page of products. The next
+// cursor is returned even on the last page so sync jobs can store it and
+// resume from there later.
+func listProductsByCursor(c *fiber.Ctx, query *productListQuery, cursor *productCursor) error {
+	db := database.DB
+
+	products := []models.Product{}
+	err := db.Scopes(withProductRelations, query.Filters, cursor.Seek).Limit(query.Limit + 1).Find(&products).Error
+	if err != nil {
+		log.Printf("Error getting products in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch products"})
+	}
+
+	response := ProductCursorResponse{Limit: query.Limit, Links: CursorLinks{Self: c.BaseURL() + c.OriginalURL()}}
+	if len(products) > query.Limit {
+		products = products[:query.Limit]
+		response.HasMore = true
+	}
+	response.Data = products
+
+	next := cursor
+	if len(products) > 0 {
+		next = cursor.After(products[len(products)-1])
+	}
+	if !next.At.IsZero() {
+		token, err := signing.Encode(next)
+		if err != nil {
+			log.Printf("Error encoding product cursor: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch products"})
+		}
+		response.NextCursor = token
+		if response.HasMore {
+			response.Links.Next = cursorURL(c, token)
+		}
+	}
+
+	return c.JSON(response)
+}
@@ -1,20 +1,17 @@
 package handlers
 
 import (
-	"context"
 	"errors"
-	"fmt"
-	"github.com/cloudinary/cloudinary-go/v2"
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"log"
-	"os"
+	"products/cleanup"
 	"products/database"
+	"products/inventory"
 	"products/models"
-	"regexp"
+	"strings"
 )
 
 type BatchRequest struct {
@@ -23,6 +20,12 @@ type BatchRequest struct {
 
 type UpdateStockRequest struct {
 	QuantityChange int64 `json:"quantity_change"`
+	// VariantID targets the stock of a single variant instead of the product.
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	// Reason is recorded in the stock history: sale, restock, adjustment
+	// (default) or return.
+	Reason      string  `json:"reason,omitempty"`
+	ReferenceID *string `json:"reference_id,omitempty"`
 }
 
 // CreateProduct godoc
@@ -32,6 +35,7 @@ type UpdateStockRequest struct {
 // @Accept      json
 // @Produce     json
 // @Param       product body models.Product true "Data of Product (ID, CreatedAt, UpdatedAt are ignored)"
+// @Param       Idempotency-Key header string false "Replay the first response when the request is retried"
 // @Success     201 {object} models.Product
 // @Security     ApiKeyAuth
 // @Router      /products [post]
@@ -42,8 +46,19 @@ func CreateProduct(c *fiber.Ctx) error {
 	if err := c.BodyParser(product); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 	}
+	product.Reserved = 0
 
-	if err := db.Create(&product).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&product).Error; err != nil {
+			return err
+		}
+		if product.Stock == 0 {
+			return nil
+		}
+		_, err := inventory.RecordMovement(tx, product.ID, nil, product.Stock, product.Stock, models.MovementRestock, nil)
+		return err
+	})
+	if err != nil {
 		log.Printf("Error creating product in database: %s", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create product"})
 	}
@@ -52,22 +67,65 @@ func CreateProduct(c *fiber.Ctx) error {
 }
 
 // GetProducts godoc
-// @Summary      List all Products
-// @Description  Return an array with all products
+// @Summary      List Products
+// @Description  Return a page of products, optionally filtered and sorted
 // @Tags         products
 // @Produce      json
-// @Success      200  {array}   models.Product
+// @Param        page           query     int     false  "Page number (default 1)"
+// @Param        limit          query     int     false  "Page size (default 20, max 100)"
+// @Param        sort           query     string  false  "Sort field (name, price, stock, created_at); prefix with - for descending"
+// @Param        min_price      query     int     false  "Minimum price"
+// @Param        max_price      query     int     false  "Maximum price"
+// @Param        in_stock       query     bool    false  "Only products with (true) or without (false) stock"
+// @Param        created_after  query     string  false  "Only products created after this RFC3339 timestamp"
+// @Param        category_id          query     string  false  "Only products in this category (UUID)"
+// @Param        include_descendants  query     bool    false  "Also include products of the subcategories of category_id"
+// @Param        pagination     query     string  false  "Pagination mode: offset (default) or cursor"
+// @Param        cursor         query     string  false  "Opaque cursor returned by a previous cursor page"
+// @Param        updated_since  query     string  false  "Start a cursor walk over products changed since this RFC3339 timestamp"
+// @Success      200  {object}  ProductListResponse  "Offset pages; cursor pages are returned as ProductCursorResponse"
+// @Failure      400  {object}  map[string]string
 // @Security     ApiKeyAuth
+// @Security     BearerAuth
 // @Router       /products [get]
 func GetProducts(c *fiber.Ctx) error {
 	db := database.DB
-	var products []models.Product
 
-	if err := db.Find(&products).Error; err != nil {
+	query, err := parseProductListQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	cursor, err := parseProductCursor(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if cursor != nil {
+		if c.Query("sort") != "" || c.Query("page") != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sort and page are not supported with cursor pagination"})
+		}
+		return listProductsByCursor(c, query, cursor)
+	}
+
+	var total int64
+	if err := db.Model(&models.Product{}).Scopes(query.Filters).Count(&total).Error; err != nil {
+		log.Printf("Error counting products in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch products"})
+	}
+
+	products := []models.Product{}
+	if err := db.Scopes(withProductRelations, query.Filters, query.Paginate).Find(&products).Error; err != nil {
 		log.Printf("Error getting products in database: %s", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch products"})
 	}
-	return c.JSON(products)
+
+	return c.JSON(ProductListResponse{
+		Data:  products,
+		Total: total,
+		Page:  query.Page,
+		Limit: query.Limit,
+		Links: query.Links(c, total),
+	})
 }
 
 // GetProductsByIDs godoc
@@ -79,6 +137,7 @@ func GetProducts(c *fiber.Ctx) error {
 // @Param        request body      BatchRequest  true  "List of product IDs"
 // @Success      200     {array}   models.Product
 // @Security     ApiKeyAuth
+// @Security     BearerAuth
 // @Router       /products/batch [post]
 
 func GetProductsByIDs(c *fiber.Ctx) error {
@@ -110,6 +169,7 @@ func GetProductsByIDs(c *fiber.Ctx) error {
 // @Success     200 {object} models.Product
 // @Failure     404 {object} map[string]string
 // @Security     ApiKeyAuth
+// @Security     BearerAuth
 // @Router      /products/{id} [get]
 func GetProductByID(c *fiber.Ctx) error {
 	db := database.DB
@@ -119,7 +179,7 @@ func GetProductByID(c *fiber.Ctx) error {
 	}
 
 	var product models.Product
-	if err := db.First(&product, id).Error; err != nil {
+	if err := db.Scopes(withProductRelations).First(&product, id).Error; err != nil {
 		log.Printf("Error getting product in database: %s", err)
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
 	}
@@ -129,7 +189,7 @@ func GetProductByID(c *fiber.Ctx) error {
 
 // PatchProduct godoc
 // @Summary      Update a Product
-// @Description  Update data of product by exists ID
+// @Description  Update data of product by exists ID. Stock is not changed here; use POST /products/{id}/stock so the change is recorded in the ledger.
 // @Tags         products
 // @Accept       json
 // @Produce      json
@@ -158,7 +218,14 @@ func PatchProduct(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 	}
 
-	delete(updateData, "image_url")
+	// Stock only changes through POST /products/:id/stock, which records the
+	// change in the ledger and respects reservations.
+	for key := range updateData {
+		switch strings.ToLower(key) {
+		case "image_url", "image_sizes", "categories", "variants", "images", "reserved", "stock":
+			delete(updateData, key)
+		}
+	}
 
 	if err := db.Model(&product).Updates(updateData).Error; err != nil {
 		log.Printf("Error updating product in database: %s", err)
@@ -174,6 +241,7 @@ func PatchProduct(c *fiber.Ctx) error {
 // @Param        id   path      string  true  "Product ID (UUID)"
 // @Success      204  {object}  nil
 // @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
 // @Security     ApiKeyAuth
 // @Router       /products/{id} [delete]
 func DeleteProduct(c *fiber.Ctx) error {
@@ -188,26 +256,46 @@ func DeleteProduct(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
 	}
 
-	if product.ImageURL != nil && *product.ImageURL != "" {
-		cld, err := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
+	err = db.Transaction(func(tx *gorm.DB) error {
+		reserved, err := inventory.HasPendingReservations(tx, id, nil)
 		if err != nil {
-			log.Printf("Error iniatializing Cloudinary for deletion: %s", err)
-		} else {
-			publicID := extractPublicIDFromURL(*product.ImageURL)
-			if publicID != "" {
-				_, err := cld.Upload.Destroy(context.Background(), uploader.DestroyParams{PublicID: publicID})
-				if err != nil {
-					log.Printf("Failed to delete image from Cloudinary with public_id %s: %v", publicID, err)
-				} else {
-					log.Printf("Successfully deleted image from Cloudinary with public_id: %s", publicID)
-				}
+			return err
+		}
+		if reserved {
+			return inventory.ErrPendingReservations
+		}
+
+		var images []models.ProductImage
+		if err := tx.Where("product_id = ?", id).Find(&images).Error; err != nil {
+			return err
+		}
+		if err := tx.Select("Categories", "Variants", "Images").Delete(&product, id).Error; err != nil {
+			return err
+		}
+		if err := cleanup.EnqueueImages(tx, images...); err != nil {
+			return err
+		}
+
+		// Images uploaded before galleries existed are only referenced by
+		// image_url.
+		if product.ImageURL == nil || *product.ImageURL == "" {
+			return nil
+		}
+		for _, image := range images {
+			if image.URL == *product.ImageURL {
+				return nil
 			}
 		}
+		return cleanup.EnqueueURL(tx, *product.ImageURL)
+	})
+	if errors.Is(err, inventory.ErrPendingReservations) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Product has pending reservations; release them before deleting it"})
 	}
-
-	result := db.Delete(&product, id)
-	if result.Error != nil {
-		log.Printf("Error deleting product: %s", result.Error)
+	if errors.Is(err, inventory.ErrProductNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+	if err != nil {
+		log.Printf("Error deleting product: %s", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete product"})
 	}
 
@@ -216,7 +304,7 @@ func DeleteProduct(c *fiber.Ctx) error {
 
 // UploadProductImage godoc
 // @Summary     Upload image from product
-// @Description Received image and associate url to product
+// @Description Received image and associate url to product. The image replaces the primary image of the product gallery, and the previous image is deleted from the storage once the new one is saved.
 // @Tags        products
 // @Accept      multipart/form-data
 // @Produce     json
@@ -225,6 +313,8 @@ func DeleteProduct(c *fiber.Ctx) error {
 // @Success     200 {object} models.Product
 // @Failure     400 {object} map[string]string
 // @Failure     404 {object} map[string]string
+// @Failure     413 {object} map[string]string
+// @Failure     415 {object} map[string]string
 // @Security     ApiKeyAuth
 // @Router      /products/{id}/upload [post]
 func UploadProductImage(c *fiber.Ctx) error {
@@ -246,52 +336,73 @@ func UploadProductImage(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Image upload failed"})
 	}
 
-	fileReader, err := file.Open()
+	uploadResult, err := storeImage(file)
 	if err != nil {
-		log.Printf("Error uploading product image: %s", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Failed to open file"})
+		return imageUploadError(c, err)
 	}
-	defer func() {
-		if err := fileReader.Close(); err != nil {
-			log.Printf("Failed to close file reader: %v", err)
-		}
-	}()
 
-	cld, err := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to initialize Cloudinary"})
+	if err := replacePrimaryImage(db, product.ID, *uploadResult); err != nil {
+		log.Printf("Error saving product image: %s", err)
+		discardImages(*uploadResult)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update product with image URL"})
 	}
 
-	uploadParams := uploader.UploadParams{
-		Folder: "sabordarondonia",
+	if err := withProductRelations(db).First(&product, product.ID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update product with image URL"})
 	}
+	return c.JSON(product)
+}
 
-	uploadResult, err := cld.Upload.Upload(context.Background(), fileReader, uploadParams)
+// DeletePrimaryImage godoc
+// @Summary      Delete the image of a Product
+// @Description  Remove the primary image of a product from its gallery and the storage. The next image of the gallery becomes the primary image, if any.
+// @Tags         products
+// @Param        id   path      string  true  "Product ID (UUID)"
+// @Success      204  {object}  nil
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/image [delete]
+func DeletePrimaryImage(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to upload file"})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
 	}
 
-	imageURL := uploadResult.URL
-	product.ImageURL = &imageURL
-
-	if err := db.Save(&product).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update product with image URL"})
+	var image models.ProductImage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, id); err != nil {
+			return err
+		}
+		err := tx.Where("product_id = ? AND is_primary = ?", id, true).First(&image).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errImageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return removeProductImage(tx, image)
+	})
+	if err != nil {
+		return imageError(c, err)
 	}
-
-	return c.JSON(product)
+	return c.SendStatus(fiber.StatusNoContent)
 }
 
 // UpdateStock godoc
 // @Summary      Updates the stock of a product
-// @Description  Adjusts a product's inventory atomically. Use a negative value to decrease inventory.
+// @Description  Adjusts a product's inventory atomically. Use a negative value to decrease inventory; stock held by pending reservations cannot be removed. Every change is recorded in the stock history with its reason. When variant_id is set, the stock of that variant is adjusted instead and the variant is returned.
 // @Tags         products
 // @Accept       json
 // @Produce      json
 // @Param        id       path      string              true  "Product ID (UUID)"
 // @Param        request  body      UpdateStockRequest  true  "Change in stock quantity"
+// @Param        Idempotency-Key  header  string  false  "Replay the first response when the request is retried"
 // @Success      200      {object}  models.Product
 // @Failure      400      {object}  map[string]string
 // @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  InsufficientStockResponse
 // @Security     ApiKeyAuth
 // @Router       /products/{id}/stock [post]
 func UpdateStock(c *fiber.Ctx) error {
@@ -306,17 +417,36 @@ func UpdateStock(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 	}
 
+	if payload.Reason == "" {
+		payload.Reason = models.MovementAdjustment
+	}
+	if !models.IsValidMovementReason(payload.Reason) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reason: expected sale, restock, adjustment or return"})
+	}
+
+	if payload.VariantID != nil {
+		return updateVariantStock(c, id, payload)
+	}
+
 	var updatedProduct models.Product
 	err = db.Transaction(func(tx *gorm.DB) error {
 		var product models.Product
 
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
-			return errors.New("product not found")
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inventory.ErrProductNotFound
+		}
+		if err != nil {
+			return err
 		}
 
 		newStock := product.Stock + payload.QuantityChange
-		if newStock < 0 {
-			return fmt.Errorf("product stock out of stock: %s", product.Name)
+		if newStock < product.Reserved {
+			return &inventory.InsufficientStockError{
+				ProductID: product.ID,
+				Available: product.Stock - product.Reserved,
+				Requested: -payload.QuantityChange,
+			}
 		}
 
 		product.Stock = newStock
@@ -324,28 +454,23 @@ func UpdateStock(c *fiber.Ctx) error {
 			return err
 		}
 
+		_, err = inventory.RecordMovement(tx, product.ID, nil, payload.QuantityChange, newStock, payload.Reason, payload.ReferenceID)
+		if err != nil {
+			return err
+		}
+
 		updatedProduct = product
 		return nil
 	})
 	if err != nil {
-		if err.Error() == "Product not found" {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
-		}
-		if errors.Is(err, gorm.ErrInvalidField) || err.Error()[:18] == "insufficient stock" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-		}
-		log.Printf("Error updating stock in transaction: %s", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update stock"})
+		return stockError(c, err, "Could not update stock")
 	}
 	return c.Status(fiber.StatusOK).JSON(updatedProduct)
 }
 
-func extractPublicIDFromURL(url string) string {
-	re := regexp.MustCompile(`/sabordarondonia/([^.]+)\.`)
-	matches := re.FindStringSubmatch(url)
-
-	if len(matches) > 1 {
-		return "sabordarondonia/" + matches[1]
-	}
-	return ""
+// withProductRelations preloads the associations returned with products.
+func withProductRelations(db *gorm.DB) *gorm.DB {
+	return db.Preload("Categories").Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sku ASC")
+	}).Preload("Images", orderedImages)
 }
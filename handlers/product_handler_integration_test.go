@@ -9,13 +9,21 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
+	"image"
+	"image/png"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"products/database"
 	"products/models"
+	"products/storage"
 	"testing"
+	"time"
 )
 
 func setupTestDB(t *testing.T) {
@@ -24,12 +32,28 @@ func setupTestDB(t *testing.T) {
 		t.Fatalf("failed to connect to test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.Product{})
+	err = db.AutoMigrate(
+		&models.Category{},
+		&models.Product{},
+		&models.Variant{},
+		&models.ProductImage{},
+		&models.Reservation{},
+		&models.ReservationLine{},
+		&models.StockMovement{},
+		&models.ImageDeletionJob{},
+		&models.APIKey{},
+		&models.ImageUploadTicket{},
+	)
 	if err != nil {
 		t.Fatalf("failed to auto migrate products: %v", err)
 	}
 
 	database.DB = db
+
+	storage.Images, err = storage.NewLocalStore(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatalf("failed to create image storage: %v", err)
+	}
 }
 
 func setupTestApp() *fiber.App {
@@ -37,10 +61,52 @@ func setupTestApp() *fiber.App {
 	api := app.Group("/api")
 	productGroup := api.Group("/products")
 	productGroup.Get("/", GetProducts)
+	productGroup.Get("/search", SearchProducts)
+	productGroup.Post("/stock/bulk", BulkUpdateStock)
 	productGroup.Get("/:id", GetProductByID)
 	productGroup.Post("/", CreateProduct)
 	productGroup.Patch("/:id", PatchProduct)
 	productGroup.Delete("/:id", DeleteProduct)
+	productGroup.Put("/:id/categories", SetProductCategories)
+	productGroup.Post("/:id/stock", UpdateStock)
+	productGroup.Post("/:id/upload", UploadProductImage)
+	productGroup.Delete("/:id/image", DeletePrimaryImage)
+	productGroup.Post("/:id/images", UploadProductImages)
+	productGroup.Post("/:id/images/import", ImportProductImage)
+	productGroup.Post("/:id/images/uploads", CreateImageUploadTicket)
+	productGroup.Post("/:id/images/uploads/complete", CompleteImageUpload)
+	productGroup.Get("/:id/images", GetProductImages)
+	productGroup.Put("/:id/images/order", ReorderProductImages)
+	productGroup.Patch("/:id/images/:imageId", PatchProductImage)
+	productGroup.Delete("/:id/images/:imageId", DeleteProductImage)
+	productGroup.Get("/:id/stock/history", GetStockHistory)
+	productGroup.Post("/:id/variants", CreateVariant)
+	productGroup.Get("/:id/variants", GetVariants)
+	productGroup.Get("/:id/variants/:variantId", GetVariantByID)
+	productGroup.Patch("/:id/variants/:variantId", PatchVariant)
+	productGroup.Delete("/:id/variants/:variantId", DeleteVariant)
+
+	reservationGroup := api.Group("/reservations")
+	reservationGroup.Post("/", CreateReservation)
+	reservationGroup.Get("/:id", GetReservationByID)
+	reservationGroup.Post("/:id/confirm", ConfirmReservation)
+	reservationGroup.Post("/:id/release", ReleaseReservation)
+
+	api.Post("/uploads/:token", ReceiveDirectUpload)
+
+	adminGroup := api.Group("/admin")
+	adminGroup.Get("/image-deletions", GetImageDeletionQueue)
+	adminGroup.Post("/api-keys", CreateAPIKey)
+	adminGroup.Get("/api-keys", GetAPIKeys)
+	adminGroup.Post("/api-keys/:id/rotate", RotateAPIKey)
+	adminGroup.Delete("/api-keys/:id", RevokeAPIKey)
+
+	categoryGroup := api.Group("/categories")
+	categoryGroup.Post("/", CreateCategory)
+	categoryGroup.Get("/", GetCategories)
+	categoryGroup.Get("/:id", GetCategoryByID)
+	categoryGroup.Patch("/:id", PatchCategory)
+	categoryGroup.Delete("/:id", DeleteCategory)
 
 	return app
 }
@@ -65,10 +131,11 @@ func TestGetProducts(t *testing.T) {
 			},
 			expectedStatus: fiber.StatusOK,
 			verifyBody: func(t *testing.T, body []byte) {
-				var returnedProducts []models.Product
-				err := json.Unmarshal(body, &returnedProducts)
+				var returned ProductListResponse
+				err := json.Unmarshal(body, &returned)
 				assert.NoError(t, err)
-				assert.Len(t, returnedProducts, 1)
+				assert.Len(t, returned.Data, 1)
+				assert.Equal(t, int64(1), returned.Total)
 			},
 		},
 		{
@@ -79,10 +146,11 @@ func TestGetProducts(t *testing.T) {
 			},
 			expectedStatus: fiber.StatusOK,
 			verifyBody: func(t *testing.T, body []byte) {
-				var returnedProducts []models.Product
-				err := json.Unmarshal(body, &returnedProducts)
+				var returned ProductListResponse
+				err := json.Unmarshal(body, &returned)
 				assert.NoError(t, err)
-				assert.Len(t, returnedProducts, 0)
+				assert.Len(t, returned.Data, 0)
+				assert.Equal(t, int64(0), returned.Total)
 			},
 		},
 		{
@@ -121,6 +189,300 @@ func TestGetProducts(t *testing.T) {
 	}
 }
 
+func TestGetProductsQuery(t *testing.T) {
+	setupTestDB(t)
+	app := setupTestApp()
+	database.DB.Exec("DELETE FROM products")
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	seed := []models.Product{
+		{Name: "Açaí", Price: 1500, Stock: 10, CreatedAt: base},
+		{Name: "Banana", Price: 300, Stock: 0, CreatedAt: base.Add(time.Hour)},
+		{Name: "Castanha", Price: 2500, Stock: 5, CreatedAt: base.Add(2 * time.Hour)},
+		{Name: "Doce de Leite", Price: 900, Stock: 2, CreatedAt: base.Add(3 * time.Hour)},
+	}
+	for i := range seed {
+		database.DB.Create(&seed[i])
+	}
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+		expectedTotal  int64
+		expectNext     bool
+	}{
+		{
+			name:           "Default - Newest first",
+			query:          "",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Doce de Leite", "Castanha", "Banana", "Açaí"},
+			expectedTotal:  4,
+		},
+		{
+			name:           "Pagination - First page",
+			query:          "?limit=3&sort=name",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Açaí", "Banana", "Castanha"},
+			expectedTotal:  4,
+			expectNext:     true,
+		},
+		{
+			name:           "Pagination - Last page",
+			query:          "?limit=3&page=2&sort=name",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Doce de Leite"},
+			expectedTotal:  4,
+		},
+		{
+			name:           "Sort - Price descending",
+			query:          "?sort=-price",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Castanha", "Açaí", "Doce de Leite", "Banana"},
+			expectedTotal:  4,
+		},
+		{
+			name:           "Filter - Price range and stock",
+			query:          "?min_price=500&max_price=2000&in_stock=true&sort=price",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Doce de Leite", "Açaí"},
+			expectedTotal:  2,
+		},
+		{
+			name:           "Filter - Created after",
+			query:          "?created_after=2025-01-01T01:30:00Z&sort=created_at",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Castanha", "Doce de Leite"},
+			expectedTotal:  2,
+		},
+		{
+			name:           "Failure - Unknown sort field",
+			query:          "?sort=description",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Invalid page",
+			query:          "?page=0",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Invalid created_after",
+			query:          "?created_after=yesterday",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/products"+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					log.Printf("failed to close response body: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != fiber.StatusOK {
+				return
+			}
+
+			var returned ProductListResponse
+			body, _ := io.ReadAll(resp.Body)
+			assert.NoError(t, json.Unmarshal(body, &returned))
+
+			names := make([]string, 0, len(returned.Data))
+			for _, product := range returned.Data {
+				names = append(names, product.Name)
+			}
+			assert.Equal(t, tc.expectedNames, names)
+			assert.Equal(t, tc.expectedTotal, returned.Total)
+			assert.Equal(t, tc.expectNext, returned.Links.Next != "")
+		})
+	}
+}
+
+func TestGetProductsCursor(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "test-signing-secret")
+	setupTestDB(t)
+	app := setupTestApp()
+	database.DB.Exec("DELETE FROM products")
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		database.DB.Create(&models.Product{
+			Name:      fmt.Sprintf("Produto %d", i),
+			Price:     100,
+			CreatedAt: base.Add(time.Duration(i/2) * time.Hour),
+			UpdatedAt: base,
+		})
+	}
+
+	fetch := func(t *testing.T, target string) (int, ProductCursorResponse) {
+		req := httptest.NewRequest("GET", target, nil)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Printf("failed to close response body: %v", err)
+			}
+		}()
+
+		var page ProductCursorResponse
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == fiber.StatusOK {
+			assert.NoError(t, json.Unmarshal(body, &page))
+		}
+		return resp.StatusCode, page
+	}
+
+	t.Run("Success - Walk whole catalog", func(t *testing.T) {
+		seen := map[uuid.UUID]bool{}
+		status, page := fetch(t, "/api/products?pagination=cursor&limit=2")
+		for {
+			assert.Equal(t, fiber.StatusOK, status)
+			for _, product := range page.Data {
+				assert.False(t, seen[product.ID], "product returned twice")
+				seen[product.ID] = true
+			}
+			if !page.HasMore {
+				break
+			}
+			// Products created during the walk must not shift the remaining pages.
+			database.DB.Create(&models.Product{Name: "Novo " + uuid.NewString(), CreatedAt: base.Add(-time.Hour)})
+			status, page = fetch(t, "/api/products?limit=2&cursor="+url.QueryEscape(page.NextCursor))
+		}
+		assert.Len(t, seen, 5)
+		assert.NotEmpty(t, page.NextCursor)
+	})
+
+	t.Run("Success - Changed since", func(t *testing.T) {
+		since := time.Now()
+		var product models.Product
+		database.DB.Where("name = ?", "Produto 3").First(&product)
+		database.DB.Model(&product).Update("price", 200)
+
+		status, page := fetch(t, "/api/products?updated_since="+url.QueryEscape(since.Format(time.RFC3339Nano)))
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Len(t, page.Data, 1)
+		assert.Equal(t, "Produto 3", page.Data[0].Name)
+		assert.False(t, page.HasMore)
+
+		status, page = fetch(t, "/api/products?cursor="+url.QueryEscape(page.NextCursor))
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Len(t, page.Data, 0)
+		assert.NotEmpty(t, page.NextCursor)
+	})
+
+	t.Run("Failure - Tampered cursor", func(t *testing.T) {
+		_, page := fetch(t, "/api/products?pagination=cursor&limit=1")
+		status, _ := fetch(t, "/api/products?cursor="+url.QueryEscape(page.NextCursor+"x"))
+		assert.Equal(t, fiber.StatusBadRequest, status)
+	})
+
+	t.Run("Failure - Sort with cursor", func(t *testing.T) {
+		status, _ := fetch(t, "/api/products?pagination=cursor&sort=name")
+		assert.Equal(t, fiber.StatusBadRequest, status)
+	})
+}
+
+func TestSearchProducts(t *testing.T) {
+	setupTestDB(t)
+	app := setupTestApp()
+	database.DB.Exec("DELETE FROM products")
+
+	description := "Feito com polvilho e queijo minas"
+	acaiDescription := "Polpa de açaí com banana"
+	seed := []models.Product{
+		{Name: "Açaí na Tigela", Price: 1800, Stock: 3},
+		{Name: "Pão de Queijo", Description: &description, Price: 500, Stock: 20},
+		{Name: "Sorvete de Cupuaçu", Description: &acaiDescription, Price: 900, Stock: 0},
+		{Name: "Pamonha", Price: 700, Stock: 8},
+	}
+	for i := range seed {
+		database.DB.Create(&seed[i])
+	}
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{
+			name:           "Accent-insensitive match",
+			query:          "?q=acai",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Açaí na Tigela", "Sorvete de Cupuaçu"},
+		},
+		{
+			name:           "Prefix match on several terms",
+			query:          "?q=pao%20qu",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Pão de Queijo"},
+		},
+		{
+			name:           "Accents in the query are ignored",
+			query:          "?q=CUPUAÇU",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Sorvete de Cupuaçu"},
+		},
+		{
+			name:           "Combined with filters",
+			query:          "?q=acai&in_stock=true",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{"Açaí na Tigela"},
+		},
+		{
+			name:           "No matches",
+			query:          "?q=chocolate",
+			expectedStatus: fiber.StatusOK,
+			expectedNames:  []string{},
+		},
+		{
+			name:           "Failure - Missing query",
+			query:          "?q=%20",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Sort is not supported",
+			query:          "?q=acai&sort=price",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/products/search"+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					log.Printf("failed to close response body: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != fiber.StatusOK {
+				return
+			}
+
+			var returned ProductListResponse
+			body, _ := io.ReadAll(resp.Body)
+			assert.NoError(t, json.Unmarshal(body, &returned))
+
+			names := make([]string, 0, len(returned.Data))
+			for _, product := range returned.Data {
+				names = append(names, product.Name)
+			}
+			assert.Equal(t, tc.expectedNames, names)
+			assert.Equal(t, int64(len(tc.expectedNames)), returned.Total)
+		})
+	}
+}
+
 func TestGetProductByID(t *testing.T) {
 	setupTestDB(t)
 	app := setupTestApp()
@@ -361,6 +723,38 @@ func TestDeleteProduct(t *testing.T) {
 				assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 			},
 		},
+		{
+			name: "Success - Legacy Images Are Queued For Deletion",
+			productID: func() string {
+				var p models.Product
+				database.DB.First(&p)
+				return p.ID.String()
+			},
+			setup: func(t *testing.T) *models.Product {
+				setupTestDB(t)
+				database.DB.Exec("DELETE FROM products")
+				database.DB.Exec("DELETE FROM product_images")
+				database.DB.Exec("DELETE FROM image_deletion_jobs")
+				imageURL := "/uploads/products/legacy.jpg"
+				mockProduct := &models.Product{ID: uuid.New(), Name: "Produto Antigo", Price: 100, ImageURL: &imageURL}
+				database.DB.Create(mockProduct)
+				// Saved before the backend and public ID were recorded.
+				database.DB.Create(&models.ProductImage{ProductID: mockProduct.ID, URL: "/uploads/products/gallery.jpg", Position: 1})
+				return mockProduct
+			},
+			expectedStatus: fiber.StatusNoContent,
+			verifyDB: func(t *testing.T, originalProduct *models.Product) {
+				var jobs []models.ImageDeletionJob
+				database.DB.Order("public_id").Find(&jobs)
+				if assert.Len(t, jobs, 2) {
+					assert.Equal(t, "products/gallery.jpg", jobs[0].PublicID)
+					assert.Equal(t, "products/legacy.jpg", jobs[1].PublicID)
+					for _, job := range jobs {
+						assert.Equal(t, storage.BackendLocal, job.StorageBackend)
+					}
+				}
+			},
+		},
 		{
 			name:      "Falha - Produto Não Encontrado",
 			productID: func() string { return uuid.New().String() },
@@ -404,3 +798,182 @@ func TestDeleteProduct(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateStock(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM products")
+	product := models.Product{Name: "Pão de Queijo", Price: 800, Stock: 5, Reserved: 2}
+	database.DB.Create(&product)
+	target := "/api/products/" + product.ID.String() + "/stock"
+
+	testCases := []struct {
+		name           string
+		target         string
+		payload        string
+		expectedStatus int
+		expectedBody   *InsufficientStockResponse
+		expectedStock  int64
+	}{
+		{
+			name:           "Success - Decrease stock",
+			target:         target,
+			payload:        `{"quantity_change":-1}`,
+			expectedStatus: fiber.StatusOK,
+			expectedStock:  4,
+		},
+		{
+			name:           "Failure - Reserved stock cannot be removed",
+			target:         target,
+			payload:        `{"quantity_change":-3}`,
+			expectedStatus: fiber.StatusConflict,
+			expectedBody: &InsufficientStockResponse{
+				Error:     "Insufficient stock",
+				ProductID: product.ID,
+				Available: 2,
+				Requested: 3,
+			},
+			expectedStock: 4,
+		},
+		{
+			name:           "Failure - Product not found",
+			target:         "/api/products/" + uuid.NewString() + "/stock",
+			payload:        `{"quantity_change":1}`,
+			expectedStatus: fiber.StatusNotFound,
+			expectedStock:  4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := sendJSON(t, app, http.MethodPost, tc.target, tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			if tc.expectedBody != nil {
+				var returned InsufficientStockResponse
+				assert.NoError(t, json.Unmarshal(body, &returned))
+				assert.Equal(t, *tc.expectedBody, returned)
+			}
+
+			var stored models.Product
+			database.DB.First(&stored, product.ID)
+			assert.Equal(t, tc.expectedStock, stored.Stock)
+		})
+	}
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func uploadImage(t *testing.T, app *fiber.App, target string, contents ...[]byte) (int, []byte) {
+	return uploadFiles(t, app, target, "image", contents...)
+}
+
+// uploadFiles posts contents as files of the field form field.
+func uploadFiles(t *testing.T, app *fiber.App, target, field string, contents ...[]byte) (int, []byte) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, content := range contents {
+		part, err := writer.CreateFormFile(field, "image.png")
+		assert.NoError(t, err)
+		_, err = part.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, respBody
+}
+
+func TestUploadProductImage(t *testing.T) {
+	app := setupTestApp()
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM products")
+	product := models.Product{Name: "Castanha-do-Pará", Price: 2500}
+	database.DB.Create(&product)
+	store := storage.Images.(*storage.LocalStore)
+
+	status, _ := uploadImage(t, app, "/api/products/"+uuid.NewString()+"/upload", testPNG(t, 2, 2))
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, body := uploadImage(t, app, "/api/products/"+product.ID.String()+"/upload", testPNG(t, 2, 2))
+	assert.Equal(t, fiber.StatusOK, status)
+	var returned models.Product
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	if !assert.NotNil(t, returned.ImageURL) {
+		return
+	}
+	assert.Regexp(t, `^/uploads/sabordarondonia/[0-9a-f-]+\.png$`, *returned.ImageURL)
+
+	publicID := store.PublicID(*returned.ImageURL)
+	stored := filepath.Join(store.Dir, filepath.FromSlash(publicID))
+	assert.FileExists(t, stored)
+
+	// Uploading again replaces the image and deletes the previous one.
+	status, body = uploadImage(t, app, "/api/products/"+product.ID.String()+"/upload", testPNG(t, 3, 3))
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	assert.Len(t, returned.Images, 1)
+	processImageDeletions(t)
+	assert.NoFileExists(t, stored)
+	stored = filepath.Join(store.Dir, filepath.FromSlash(store.PublicID(*returned.ImageURL)))
+	assert.FileExists(t, stored)
+
+	// Deleting the product removes its image from the storage backend.
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	processImageDeletions(t)
+	assert.NoFileExists(t, stored)
+
+	// A product uploaded before galleries existed only has an image_url, whose
+	// file is deleted when a new image replaces it.
+	legacy := filepath.Join(store.Dir, "sabordarondonia", "legacy.png")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(legacy), 0o755))
+	assert.NoError(t, os.WriteFile(legacy, testPNG(t, 2, 2), 0o644))
+	legacyURL := store.BaseURL + "/sabordarondonia/legacy.png"
+	product = models.Product{Name: "Cupuaçu", Price: 1800, ImageURL: &legacyURL}
+	database.DB.Create(&product)
+
+	status, _ = uploadImage(t, app, "/api/products/"+product.ID.String()+"/upload", testPNG(t, 3, 3))
+	assert.Equal(t, fiber.StatusOK, status)
+	processImageDeletions(t)
+	assert.NoFileExists(t, legacy)
+}
+
+func TestDeletePrimaryImage(t *testing.T) {
+	app := setupTestApp()
+	product := resetImages(t)
+	store := storage.Images.(*storage.LocalStore)
+	images := addImages(t, app, product, 2)
+	target := "/api/products/" + product.ID.String() + "/image"
+
+	status, _ := sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	processImageDeletions(t)
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(images[0].PublicID)))
+	assert.Equal(t, images[1].URL, *imageURLOf(product.ID), "the next image becomes primary")
+
+	status, _ = sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	assert.Nil(t, imageURLOf(product.ID))
+
+	status, _ = sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/products/"+uuid.NewString()+"/image", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
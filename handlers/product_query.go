@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net/url"
+	"products/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// sortableProductColumns maps the values accepted by the sort query parameter
+// to the columns they order by.
+var sortableProductColumns = map[string]string{
+	"name":       "name",
+	"price":      "price",
+	"stock":      "stock",
+	"created_at": "created_at",
+}
+
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type ProductListResponse struct {
+	Data  []models.Product `json:"data"`
+	Total int64            `json:"total"`
+	Page  int              `json:"page"`
+	Limit int              `json:"limit"`
+	Links PageLinks        `json:"links"`
+}
+
+// pagination is the page and page size requested through the page and limit
+// query parameters.
+type pagination struct {
+	Page  int
+	Limit int
+}
+
+func parsePagination(c *fiber.Ctx) (pagination, error) {
+	page := pagination{Page: 1, Limit: defaultPageSize}
+
+	var err error
+	if page.Page, err = parsePositiveInt(c.Query("page"), page.Page); err != nil {
+		return page, fmt.Errorf("invalid page: %w", err)
+	}
+	if page.Limit, err = parsePositiveInt(c.Query("limit"), page.Limit); err != nil {
+		return page, fmt.Errorf("invalid limit: %w", err)
+	}
+	if page.Limit > maxPageSize {
+		page.Limit = maxPageSize
+	}
+	return page, nil
+}
+
+// Offset slices a query to the requested page.
+func (p pagination) Offset(db *gorm.DB) *gorm.DB {
+	return db.Offset((p.Page - 1) * p.Limit).Limit(p.Limit)
+}
+
+func (p pagination) Links(c *fiber.Ctx, total int64) PageLinks {
+	links := PageLinks{Self: pageURL(c, p.Page)}
+	if int64(p.Page*p.Limit) < total {
+		links.Next = pageURL(c, p.Page+1)
+	}
+	if p.Page > 1 {
+		links.Prev = pageURL(c, p.Page-1)
+	}
+	return links
+}
+
+type productListQuery struct {
+	pagination
+	SortColumn   string
+	SortDesc     bool
+	MinPrice     *int64
+	MaxPrice     *int64
+	InStock      *bool
+	CreatedAfter *time.Time
+	CategoryID   *uuid.UUID
+	// IncludeDescendants widens the category filter to every category below
+	// CategoryID in the tree.
+	IncludeDescendants bool
+}
+
+// parseProductListQuery reads the pagination, sorting and filtering query
+// parameters of the product listing. Missing parameters fall back to their
+// defaults; malformed ones are reported as errors.
+func parseProductListQuery(c *fiber.Ctx) (*productListQuery, error) {
+	page, err := parsePagination(c)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &productListQuery{
+		pagination: page,
+		SortColumn: "created_at",
+		SortDesc:   true,
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		field := strings.TrimPrefix(sort, "-")
+		column, ok := sortableProductColumns[field]
+		if !ok {
+			return nil, fmt.Errorf("invalid sort: unknown field %q", field)
+		}
+		query.SortColumn = column
+		query.SortDesc = strings.HasPrefix(sort, "-")
+	}
+
+	if query.MinPrice, err = parseOptionalInt64(c.Query("min_price")); err != nil {
+		return nil, fmt.Errorf("invalid min_price: %w", err)
+	}
+	if query.MaxPrice, err = parseOptionalInt64(c.Query("max_price")); err != nil {
+		return nil, fmt.Errorf("invalid max_price: %w", err)
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return nil, errors.New("invalid price range: min_price is greater than max_price")
+	}
+
+	if raw := c.Query("in_stock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("invalid in_stock: must be true or false")
+		}
+		query.InStock = &inStock
+	}
+
+	if raw := c.Query("created_after"); raw != "" {
+		createdAfter, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, errors.New("invalid created_after: expected RFC3339 timestamp")
+		}
+		query.CreatedAfter = &createdAfter
+	}
+
+	if raw := c.Query("category_id"); raw != "" {
+		categoryID, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, errors.New("invalid category_id: expected UUID")
+		}
+		query.CategoryID = &categoryID
+		query.IncludeDescendants = c.QueryBool("include_descendants")
+	}
+
+	return query, nil
+}
+
+// Filters narrows a products query down to the rows matching the request.
+func (q *productListQuery) Filters(db *gorm.DB) *gorm.DB {
+	if q.MinPrice != nil {
+		db = db.Where("price >= ?", *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		db = db.Where("price <= ?", *q.MaxPrice)
+	}
+	if q.InStock != nil {
+		if *q.InStock {
+			db = db.Where("stock - reserved > 0")
+		} else {
+			db = db.Where("stock - reserved <= 0")
+		}
+	}
+	if q.CreatedAfter != nil {
+		db = db.Where("created_at > ?", *q.CreatedAfter)
+	}
+	if q.CategoryID != nil {
+		categories := "?"
+		if q.IncludeDescendants {
+			categories = categoryDescendantsSQL
+		}
+		db = db.Where("id IN (SELECT product_id FROM product_categories WHERE category_id IN ("+categories+"))", *q.CategoryID)
+	}
+	return db
+}
+
+// Paginate orders and slices a products query to the requested page. The id
+// column is always used as a tie breaker so pages do not overlap.
+func (q *productListQuery) Paginate(db *gorm.DB) *gorm.DB {
+	direction := "ASC"
+	if q.SortDesc {
+		direction = "DESC"
+	}
+	return q.Offset(db.
+		Order(fmt.Sprintf("%s %s", q.SortColumn, direction)).
+		Order(fmt.Sprintf("id %s", direction)))
+}
+
+// pageURL rebuilds the current request URL, keeping every query parameter
+// but the page number.
+func pageURL(c *fiber.Ctx, page int) string {
+	values := url.Values{}
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		values.Add(string(key), string(value))
+	})
+	values.Set("page", strconv.Itoa(page))
+	return c.BaseURL() + c.Path() + "?" + values.Encode()
+}
+
+func parsePositiveInt(raw string, defaultValue int) (int, error) {
+	if raw == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 {
+		return 0, errors.New("must be a positive integer")
+	}
+	return value, nil
+}
+
+func parseOptionalInt64(raw string) (*int64, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, errors.New("must be an integer")
+	}
+	return &value, nil
+}
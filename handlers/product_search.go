@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"products/database"
+	"products/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchProducts godoc
+// @Summary      Search Products
+// @Description  Full-text search over product names and descriptions. Matching ignores case and accents and treats every term as a prefix, so "acai" finds "Açaí". Results are ranked with name matches first and cannot be sorted otherwise: the sort parameter of the product list is rejected with 400.
+// @Tags         products
+// @Produce      json
+// @Param        q          query     string  true   "Search terms"
+// @Param        page       query     int     false  "Page number (default 1)"
+// @Param        limit      query     int     false  "Page size (default 20, max 100)"
+// @Param        min_price  query     int     false  "Minimum price"
+// @Param        max_price  query     int     false  "Maximum price"
+// @Param        in_stock   query     bool    false  "Only products with (true) or without (false) stock"
+// @Success      200  {object}  ProductListResponse
+// @Failure      400  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /products/search [get]
+func SearchProducts(c *fiber.Ctx) error {
+	db := database.DB
+
+	terms := searchTerms(c.Query("q"))
+	if len(terms) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Query parameter q is required"})
+	}
+
+	if c.Query("sort") != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sort is not supported by search, results are ordered by relevance"})
+	}
+
+	query, err := parseProductListQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	search := searchProductsInMemory
+	if db.Dialector.Name() == "postgres" {
+		search = searchProductsFullText
+	}
+
+	products, total, err := search(db.Scopes(query.Filters), terms, query)
+	if err != nil {
+		log.Printf("Error searching products in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not search products"})
+	}
+
+	return c.JSON(ProductListResponse{
+		Data:  products,
+		Total: total,
+		Page:  query.Page,
+		Limit: query.Limit,
+		Links: query.Links(c, total),
+	})
+}
+
+// searchProductsFullText ranks products with the Postgres full-text index
+// created by database.Connect.
+func searchProductsFullText(db *gorm.DB, terms []string, query *productListQuery) ([]models.Product, int64, error) {
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	tsQuery := strings.Join(prefixes, " & ")
+
+	matches := db.
+		Where(database.ProductSearchVector+" @@ to_tsquery('simple', products_unaccent(?))", tsQuery).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := matches.Model(&models.Product{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	products := []models.Product{}
+	err := matches.
+		Scopes(withProductRelations).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "ts_rank(" + database.ProductSearchVector + ", to_tsquery('simple', products_unaccent(?))) DESC, name ASC",
+			Vars:               []interface{}{tsQuery},
+			WithoutParentheses: true,
+		}}).
+		Scopes(query.Offset).
+		Find(&products).Error
+	return products, total, err
+}
+
+// searchProductsInMemory is the fallback used by engines without full-text
+// search, such as the SQLite database of the tests. It applies the same
+// matching rules as the Postgres index: every term must prefix a word of the
+// name or description, and name matches rank higher.
+func searchProductsInMemory(db *gorm.DB, terms []string, query *productListQuery) ([]models.Product, int64, error) {
+	var candidates []models.Product
+	if err := db.Scopes(withProductRelations).Find(&candidates).Error; err != nil {
+		return nil, 0, err
+	}
+
+	type rankedProduct struct {
+		product models.Product
+		rank    float64
+	}
+
+	var ranked []rankedProduct
+	for _, product := range candidates {
+		nameWords := searchTerms(product.Name)
+		var descriptionWords []string
+		if product.Description != nil {
+			descriptionWords = searchTerms(*product.Description)
+		}
+
+		rank := 0.0
+		for _, term := range terms {
+			if hasPrefixedWord(nameWords, term) {
+				rank += 1.0
+			} else if hasPrefixedWord(descriptionWords, term) {
+				rank += 0.4
+			} else {
+				rank = 0
+				break
+			}
+		}
+		if rank > 0 {
+			ranked = append(ranked, rankedProduct{product: product, rank: rank})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank > ranked[j].rank
+		}
+		return ranked[i].product.Name < ranked[j].product.Name
+	})
+
+	products := []models.Product{}
+	start := (query.Page - 1) * query.Limit
+	for i := start; i < len(ranked) && i < start+query.Limit; i++ {
+		products = append(products, ranked[i].product)
+	}
+	return products, int64(len(ranked)), nil
+}
+
+// searchTerms lowercases text, strips its accents and splits it into words,
+// so "Pão de Queijo" becomes ["pao", "de", "queijo"].
+func searchTerms(text string) []string {
+	unaccent := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	normalized, _, err := transform.String(unaccent, strings.ToLower(text))
+	if err != nil {
+		normalized = strings.ToLower(text)
+	}
+	return strings.FieldsFunc(normalized, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func hasPrefixedWord(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
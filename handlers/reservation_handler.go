@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"products/database"
+	"products/inventory"
+	"products/models"
+)
+
+type ReservationLineRequest struct {
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Quantity  int64      `json:"quantity"`
+}
+
+type CreateReservationRequest struct {
+	Lines []ReservationLineRequest `json:"lines"`
+}
+
+// CreateReservation godoc
+// @Summary      Reserve stock
+// @Description  Hold stock for a list of products or variants during checkout. Reserved quantities are no longer available to other reservations or stock decrements until the reservation is confirmed, released or expires.
+// @Tags         reservations
+// @Accept       json
+// @Produce      json
+// @Param        request  body      CreateReservationRequest  true  "Lines to reserve"
+// @Success      201      {object}  models.Reservation
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /reservations [post]
+func CreateReservation(c *fiber.Ctx) error {
+	db := database.DB
+	payload := new(CreateReservationRequest)
+
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if len(payload.Lines) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one line is required"})
+	}
+
+	lines := make([]models.ReservationLine, 0, len(payload.Lines))
+	for _, line := range payload.Lines {
+		if line.Quantity <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Quantities must be greater than zero"})
+		}
+		lines = append(lines, models.ReservationLine{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Quantity:  line.Quantity,
+		})
+	}
+
+	reservation, err := inventory.Reserve(db, lines, inventory.ReservationTTL())
+	if err != nil {
+		return reservationError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+// GetReservationByID godoc
+// @Summary      Find reservation by id
+// @Description  Return a reservation with its lines
+// @Tags         reservations
+// @Produce      json
+// @Param        id   path      string  true  "Reservation ID (UUID)"
+// @Success      200  {object}  models.Reservation
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /reservations/{id} [get]
+func GetReservationByID(c *fiber.Ctx) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	var reservation models.Reservation
+	if err := db.Preload("Lines").First(&reservation, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error getting reservation in database: %s", err)
+		}
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Reservation not found"})
+	}
+	return c.JSON(reservation)
+}
+
+// ConfirmReservation godoc
+// @Summary      Confirm a reservation
+// @Description  Turn a pending reservation into a sale, removing its quantities from stock
+// @Tags         reservations
+// @Produce      json
+// @Param        id   path      string  true  "Reservation ID (UUID)"
+// @Success      200  {object}  models.Reservation
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /reservations/{id}/confirm [post]
+func ConfirmReservation(c *fiber.Ctx) error {
+	return closeReservation(c, inventory.Confirm)
+}
+
+// ReleaseReservation godoc
+// @Summary      Release a reservation
+// @Description  Cancel a pending reservation, making its quantities available again
+// @Tags         reservations
+// @Produce      json
+// @Param        id   path      string  true  "Reservation ID (UUID)"
+// @Success      200  {object}  models.Reservation
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /reservations/{id}/release [post]
+func ReleaseReservation(c *fiber.Ctx) error {
+	return closeReservation(c, inventory.Release)
+}
+
+func closeReservation(c *fiber.Ctx, transition func(*gorm.DB, uuid.UUID) (*models.Reservation, error)) error {
+	db := database.DB
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	reservation, err := transition(db, id)
+	if err != nil {
+		return reservationError(c, err)
+	}
+	return c.JSON(reservation)
+}
+
+func reservationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrReservationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, inventory.ErrReservationExpired),
+		errors.Is(err, inventory.ErrReservationClosed):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return stockError(c, err, "Could not process reservation")
+}
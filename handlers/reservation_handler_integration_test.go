@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"products/database"
+	"products/inventory"
+	"products/models"
+	"testing"
+	"time"
+)
+
+func resetReservations(t *testing.T) (models.Product, models.Variant) {
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM reservation_lines")
+	database.DB.Exec("DELETE FROM reservations")
+	database.DB.Exec("DELETE FROM variants")
+	database.DB.Exec("DELETE FROM products")
+
+	product := models.Product{Name: "Guaraná em Pó", Price: 1500, Stock: 10}
+	database.DB.Create(&product)
+	variant := models.Variant{ProductID: product.ID, SKU: "GUARANA-1KG", Price: 9000, Stock: 3}
+	database.DB.Create(&variant)
+	return product, variant
+}
+
+func reserve(t *testing.T, app *fiber.App, payload string) (int, models.Reservation) {
+	status, body := sendJSON(t, app, http.MethodPost, "/api/reservations", payload)
+	var reservation models.Reservation
+	if status == fiber.StatusCreated {
+		assert.NoError(t, json.Unmarshal(body, &reservation))
+	}
+	return status, reservation
+}
+
+func stockOf(productID uuid.UUID) (int64, int64) {
+	var product models.Product
+	database.DB.First(&product, productID)
+	return product.Stock, product.Reserved
+}
+
+func TestCreateReservation(t *testing.T) {
+	app := setupTestApp()
+	product, variant := resetReservations(t)
+
+	testCases := []struct {
+		name             string
+		payload          string
+		expectedStatus   int
+		expectedReserved int64
+	}{
+		{
+			name:             "Success - Reserve product and variant",
+			payload:          fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":4},{"product_id":"%s","variant_id":"%s","quantity":2}]}`, product.ID, product.ID, variant.ID),
+			expectedStatus:   fiber.StatusCreated,
+			expectedReserved: 4,
+		},
+		{
+			name:             "Failure - Reserved stock is not available",
+			payload:          fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":7}]}`, product.ID),
+			expectedStatus:   fiber.StatusConflict,
+			expectedReserved: 4,
+		},
+		{
+			name:             "Failure - All or nothing",
+			payload:          fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":1},{"product_id":"%s","variant_id":"%s","quantity":2}]}`, product.ID, product.ID, variant.ID),
+			expectedStatus:   fiber.StatusConflict,
+			expectedReserved: 4,
+		},
+		{
+			name:             "Failure - Product not found",
+			payload:          fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":1}]}`, uuid.New()),
+			expectedStatus:   fiber.StatusNotFound,
+			expectedReserved: 4,
+		},
+		{
+			name:             "Failure - Invalid quantity",
+			payload:          fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":0}]}`, product.ID),
+			expectedStatus:   fiber.StatusBadRequest,
+			expectedReserved: 4,
+		},
+		{
+			name:             "Failure - No lines",
+			payload:          `{"lines":[]}`,
+			expectedStatus:   fiber.StatusBadRequest,
+			expectedReserved: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := reserve(t, app, tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			stock, reserved := stockOf(product.ID)
+			assert.Equal(t, int64(10), stock)
+			assert.Equal(t, tc.expectedReserved, reserved)
+		})
+	}
+
+	var storedVariant models.Variant
+	database.DB.First(&storedVariant, variant.ID)
+	assert.Equal(t, int64(2), storedVariant.Reserved)
+}
+
+func TestReservationLifecycle(t *testing.T) {
+	app := setupTestApp()
+	product, _ := resetReservations(t)
+	payload := fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":4}]}`, product.ID)
+
+	_, confirmed := reserve(t, app, payload)
+	_, released := reserve(t, app, payload)
+
+	// Reserved stock cannot be removed through the stock endpoint.
+	sendJSON(t, app, http.MethodPost, "/api/products/"+product.ID.String()+"/stock", `{"quantity_change":-3}`)
+	stock, reserved := stockOf(product.ID)
+	assert.Equal(t, int64(10), stock)
+	assert.Equal(t, int64(8), reserved)
+
+	status, _ := sendJSON(t, app, http.MethodPost, "/api/reservations/"+confirmed.ID.String()+"/confirm", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	stock, reserved = stockOf(product.ID)
+	assert.Equal(t, int64(6), stock)
+	assert.Equal(t, int64(4), reserved)
+
+	status, _ = sendJSON(t, app, http.MethodPost, "/api/reservations/"+released.ID.String()+"/release", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	stock, reserved = stockOf(product.ID)
+	assert.Equal(t, int64(6), stock)
+	assert.Equal(t, int64(0), reserved)
+
+	status, _ = sendJSON(t, app, http.MethodPost, "/api/reservations/"+confirmed.ID.String()+"/release", "")
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	status, body := sendJSON(t, app, http.MethodGet, "/api/reservations/"+confirmed.ID.String(), "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var fetched models.Reservation
+	assert.NoError(t, json.Unmarshal(body, &fetched))
+	assert.Equal(t, models.ReservationConfirmed, fetched.Status)
+	assert.Len(t, fetched.Lines, 1)
+
+	status, _ = sendJSON(t, app, http.MethodPost, "/api/reservations/"+uuid.NewString()+"/confirm", "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestReservationExpiry(t *testing.T) {
+	app := setupTestApp()
+	product, _ := resetReservations(t)
+	payload := fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":3}]}`, product.ID)
+
+	_, reaped := reserve(t, app, payload)
+	_, late := reserve(t, app, payload)
+	_, active := reserve(t, app, payload)
+
+	past := time.Now().Add(-time.Minute)
+	database.DB.Model(&models.Reservation{}).Where("id IN (?)", []uuid.UUID{reaped.ID, late.ID}).Update("expires_at", past)
+
+	// Confirming after the expiry releases the stock instead of selling it.
+	status, _ := sendJSON(t, app, http.MethodPost, "/api/reservations/"+late.ID.String()+"/confirm", "")
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	expired, err := inventory.ExpireReservations(database.DB, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	for _, id := range []uuid.UUID{reaped.ID, late.ID} {
+		var reservation models.Reservation
+		database.DB.First(&reservation, id)
+		assert.Equal(t, models.ReservationExpired, reservation.Status)
+	}
+	var stillActive models.Reservation
+	database.DB.First(&stillActive, active.ID)
+	assert.Equal(t, models.ReservationPending, stillActive.Status)
+
+	stock, reserved := stockOf(product.ID)
+	assert.Equal(t, int64(10), stock)
+	assert.Equal(t, int64(3), reserved)
+}
+
+func TestReservationExpirySkipsDeletedProducts(t *testing.T) {
+	app := setupTestApp()
+	product, _ := resetReservations(t)
+	gone := models.Product{Name: "Castanha-do-Pará", Price: 2500, Stock: 5}
+	database.DB.Create(&gone)
+
+	_, orphaned := reserve(t, app, fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":1}]}`, gone.ID))
+	_, reserved := reserve(t, app, fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":2}]}`, product.ID))
+	database.DB.Delete(&models.Product{}, gone.ID)
+
+	past := time.Now().Add(-time.Minute)
+	database.DB.Model(&models.Reservation{}).Where("id IN (?)", []uuid.UUID{orphaned.ID, reserved.ID}).Update("expires_at", past)
+
+	expired, err := inventory.ExpireReservations(database.DB, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, expired)
+	for _, id := range []uuid.UUID{orphaned.ID, reserved.ID} {
+		var reservation models.Reservation
+		database.DB.First(&reservation, id)
+		assert.Equal(t, models.ReservationExpired, reservation.Status)
+	}
+	_, stillReserved := stockOf(product.ID)
+	assert.Equal(t, int64(0), stillReserved)
+}
+
+func TestDeleteReservedProduct(t *testing.T) {
+	app := setupTestApp()
+	product, variant := resetReservations(t)
+	_, reservation := reserve(t, app, fmt.Sprintf(`{"lines":[{"product_id":"%s","variant_id":"%s","quantity":1}]}`, product.ID, variant.ID))
+
+	status, _ := sendJSON(t, app, http.MethodDelete, fmt.Sprintf("/api/products/%s/variants/%s", product.ID, variant.ID), "")
+	assert.Equal(t, fiber.StatusConflict, status)
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	status, _ = sendJSON(t, app, http.MethodPost, "/api/reservations/"+reservation.ID.String()+"/release", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log"
+	"products/database"
+	"products/inventory"
+	"products/models"
+)
+
+// maxBulkStockLines bounds how many rows a single bulk adjustment locks.
+const maxBulkStockLines = 100
+
+type BulkStockLine struct {
+	ProductID      uuid.UUID  `json:"product_id"`
+	VariantID      *uuid.UUID `json:"variant_id,omitempty"`
+	QuantityChange int64      `json:"quantity_change"`
+}
+
+type BulkStockRequest struct {
+	Lines []BulkStockLine `json:"lines"`
+	// Reason and ReferenceID are recorded in the stock history of every line.
+	Reason      string  `json:"reason,omitempty"`
+	ReferenceID *string `json:"reference_id,omitempty"`
+}
+
+type BulkStockResponse struct {
+	Movements []models.StockMovement `json:"movements"`
+}
+
+type BulkStockFailure struct {
+	Index     int        `json:"index"`
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Error     string     `json:"error"`
+	// Available and Requested are set when the line failed for lack of stock.
+	Available *int64 `json:"available,omitempty"`
+	Requested *int64 `json:"requested,omitempty"`
+}
+
+type BulkStockErrorResponse struct {
+	Error    string             `json:"error"`
+	Failures []BulkStockFailure `json:"failures"`
+}
+
+type InsufficientStockResponse struct {
+	Error     string     `json:"error"`
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Available int64      `json:"available"`
+	Requested int64      `json:"requested"`
+}
+
+type StockHistoryResponse struct {
+	Data  []models.StockMovement `json:"data"`
+	Total int64                  `json:"total"`
+	Page  int                    `json:"page"`
+	Limit int                    `json:"limit"`
+	Links PageLinks              `json:"links"`
+}
+
+// GetStockHistory godoc
+// @Summary      Stock history of a Product
+// @Description  List the stock movements of a product, newest first. The history is kept after the product is deleted.
+// @Tags         products
+// @Produce      json
+// @Param        id          path      string  true   "Product ID (UUID)"
+// @Param        variant_id  query     string  false  "Only movements of this variant (UUID)"
+// @Param        page        query     int     false  "Page number, starting at 1"
+// @Param        limit       query     int     false  "Page size (max 100)"
+// @Success      200         {object}  StockHistoryResponse
+// @Failure      400         {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /products/{id}/stock/history [get]
+func GetStockHistory(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	page, err := parsePagination(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	query := db.Model(&models.StockMovement{}).Where("product_id = ?", productID)
+	if raw := c.Query("variant_id"); raw != "" {
+		variantID, err := uuid.Parse(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid variant_id: expected UUID"})
+		}
+		query = query.Where("variant_id = ?", variantID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Printf("Error counting stock movements in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch stock history"})
+	}
+
+	movements := []models.StockMovement{}
+	err = query.Scopes(page.Offset).Order("created_at DESC").Order("id DESC").Find(&movements).Error
+	if err != nil {
+		log.Printf("Error getting stock movements in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch stock history"})
+	}
+
+	return c.JSON(StockHistoryResponse{
+		Data:  movements,
+		Total: total,
+		Page:  page.Page,
+		Limit: page.Limit,
+		Links: page.Links(c, total),
+	})
+}
+
+// BulkUpdateStock godoc
+// @Summary      Update the stock of several Products
+// @Description  Applies a list of stock changes atomically: either every line is applied or none is. Lines can target a product or one of its variants. When lines fail, every failing line is returned with the reason and no stock is changed.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        request  body      BulkStockRequest  true  "Stock changes"
+// @Param        Idempotency-Key  header  string  false  "Replay the first response when the request is retried"
+// @Success      200      {object}  BulkStockResponse
+// @Failure      400      {object}  map[string]string
+// @Failure      409      {object}  BulkStockErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /products/stock/bulk [post]
+func BulkUpdateStock(c *fiber.Ctx) error {
+	db := database.DB
+	payload := new(BulkStockRequest)
+
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if len(payload.Lines) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one line is required"})
+	}
+	if len(payload.Lines) > maxBulkStockLines {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Too many lines in a single request"})
+	}
+
+	if payload.Reason == "" {
+		payload.Reason = models.MovementAdjustment
+	}
+	if !models.IsValidMovementReason(payload.Reason) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reason: expected sale, restock, adjustment or return"})
+	}
+
+	adjustments := make([]inventory.Adjustment, 0, len(payload.Lines))
+	for _, line := range payload.Lines {
+		if line.QuantityChange == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Quantity changes cannot be zero"})
+		}
+		adjustments = append(adjustments, inventory.Adjustment{
+			ProductID:      line.ProductID,
+			VariantID:      line.VariantID,
+			QuantityChange: line.QuantityChange,
+		})
+	}
+
+	movements, err := inventory.AdjustStock(db, adjustments, payload.Reason, payload.ReferenceID)
+	var adjustmentErr *inventory.AdjustmentError
+	if errors.As(err, &adjustmentErr) {
+		failures := make([]BulkStockFailure, 0, len(adjustmentErr.Failures))
+		for _, failure := range adjustmentErr.Failures {
+			line := payload.Lines[failure.Index]
+			returned := BulkStockFailure{
+				Index:     failure.Index,
+				ProductID: line.ProductID,
+				VariantID: line.VariantID,
+				Error:     failure.Err.Error(),
+			}
+			var insufficient *inventory.InsufficientStockError
+			if errors.As(failure.Err, &insufficient) {
+				returned.Available = &insufficient.Available
+				returned.Requested = &insufficient.Requested
+			}
+			failures = append(failures, returned)
+		}
+		return c.Status(fiber.StatusConflict).JSON(BulkStockErrorResponse{
+			Error:    "Stock was not updated",
+			Failures: failures,
+		})
+	}
+	if err != nil {
+		log.Printf("Error applying bulk stock update: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update stock"})
+	}
+
+	return c.JSON(BulkStockResponse{Movements: movements})
+}
+
+// stockError responds to the errors of the inventory package: missing products
+// and variants are 404, a lack of stock is 409 with the available and
+// requested quantities. Other errors are logged and answered with message.
+func stockError(c *fiber.Ctx, err error, message string) error {
+	var insufficient *inventory.InsufficientStockError
+	switch {
+	case errors.As(err, &insufficient):
+		return c.Status(fiber.StatusConflict).JSON(InsufficientStockResponse{
+			Error:     "Insufficient stock",
+			ProductID: insufficient.ProductID,
+			VariantID: insufficient.VariantID,
+			Available: insufficient.Available,
+			Requested: insufficient.Requested,
+		})
+	case errors.Is(err, inventory.ErrProductNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	case errors.Is(err, inventory.ErrVariantNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Variant not found"})
+	}
+	log.Printf("Error changing stock: %s", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": message})
+}
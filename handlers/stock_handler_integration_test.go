@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"products/database"
+	"products/models"
+	"testing"
+)
+
+func resetStockMovements(t *testing.T) (models.Product, models.Variant) {
+	product, variant := resetReservations(t)
+	database.DB.Exec("DELETE FROM stock_movements")
+	return product, variant
+}
+
+func stockHistory(t *testing.T, app *fiber.App, productID uuid.UUID, query string) StockHistoryResponse {
+	status, body := sendJSON(t, app, http.MethodGet, "/api/products/"+productID.String()+"/stock/history"+query, "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var history StockHistoryResponse
+	assert.NoError(t, json.Unmarshal(body, &history))
+	return history
+}
+
+func TestUpdateStockRecordsMovement(t *testing.T) {
+	app := setupTestApp()
+	product, variant := resetStockMovements(t)
+	target := "/api/products/" + product.ID.String() + "/stock"
+
+	testCases := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedTotal  int64
+	}{
+		{
+			name:           "Success - Restock with reference",
+			payload:        `{"quantity_change":5,"reason":"restock","reference_id":"PO-1001"}`,
+			expectedStatus: fiber.StatusOK,
+			expectedTotal:  1,
+		},
+		{
+			name:           "Success - Defaults to adjustment",
+			payload:        `{"quantity_change":-2}`,
+			expectedStatus: fiber.StatusOK,
+			expectedTotal:  2,
+		},
+		{
+			name:           "Success - Variant return",
+			payload:        fmt.Sprintf(`{"variant_id":"%s","quantity_change":1,"reason":"return"}`, variant.ID),
+			expectedStatus: fiber.StatusOK,
+			expectedTotal:  3,
+		},
+		{
+			name:           "Failure - Unknown reason",
+			payload:        `{"quantity_change":1,"reason":"gift"}`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedTotal:  3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPost, target, tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			var total int64
+			database.DB.Model(&models.StockMovement{}).Where("product_id = ?", product.ID).Count(&total)
+			assert.Equal(t, tc.expectedTotal, total)
+		})
+	}
+
+	history := stockHistory(t, app, product.ID, "")
+	assert.Equal(t, int64(3), history.Total)
+	assert.Len(t, history.Data, 3)
+
+	var restock models.StockMovement
+	database.DB.Where("reason = ?", models.MovementRestock).First(&restock)
+	assert.Equal(t, int64(5), restock.Delta)
+	assert.Equal(t, int64(15), restock.Balance)
+	assert.Equal(t, "PO-1001", *restock.ReferenceID)
+
+	variantHistory := stockHistory(t, app, product.ID, "?variant_id="+variant.ID.String())
+	assert.Equal(t, int64(1), variantHistory.Total)
+	assert.Equal(t, models.MovementReturn, variantHistory.Data[0].Reason)
+	assert.Equal(t, int64(4), variantHistory.Data[0].Balance)
+}
+
+func TestStockHistory(t *testing.T) {
+	app := setupTestApp()
+	product, _ := resetStockMovements(t)
+
+	_, reservation := reserve(t, app, fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity":4}]}`, product.ID))
+	status, _ := sendJSON(t, app, http.MethodPost, "/api/reservations/"+reservation.ID.String()+"/confirm", "")
+	assert.Equal(t, fiber.StatusOK, status)
+	for i := 0; i < 2; i++ {
+		sendJSON(t, app, http.MethodPost, "/api/products/"+product.ID.String()+"/stock", `{"quantity_change":1,"reason":"restock"}`)
+	}
+
+	history := stockHistory(t, app, product.ID, "?limit=2")
+	assert.Equal(t, int64(3), history.Total)
+	assert.Len(t, history.Data, 2)
+	assert.NotEmpty(t, history.Links.Next)
+
+	lastPage := stockHistory(t, app, product.ID, "?limit=2&page=2")
+	assert.Len(t, lastPage.Data, 1)
+	sale := lastPage.Data[0]
+	assert.Equal(t, models.MovementSale, sale.Reason)
+	assert.Equal(t, int64(-4), sale.Delta)
+	assert.Equal(t, int64(6), sale.Balance)
+	assert.Equal(t, reservation.ID.String(), *sale.ReferenceID)
+
+	// Movements are append-only and outlive the product.
+	assert.Error(t, database.DB.Delete(&sale).Error)
+	status, _ = sendJSON(t, app, http.MethodDelete, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+	assert.Equal(t, int64(3), stockHistory(t, app, product.ID, "").Total)
+
+	status, _ = sendJSON(t, app, http.MethodGet, "/api/products/"+product.ID.String()+"/stock/history?variant_id=abc", "")
+	assert.Equal(t, fiber.StatusBadRequest, status)
+}
+
+func TestBulkUpdateStock(t *testing.T) {
+	app := setupTestApp()
+	product, variant := resetStockMovements(t)
+	other := models.Product{Name: "Erva-Mate", Price: 1800, Stock: 2}
+	database.DB.Create(&other)
+	missing := uuid.New()
+
+	testCases := []struct {
+		name             string
+		payload          string
+		expectedStatus   int
+		expectedFailures []int
+		expectedStock    [3]int64
+	}{
+		{
+			name: "Success - Apply every line",
+			payload: fmt.Sprintf(`{"reason":"sale","reference_id":"ORDER-42","lines":[{"product_id":"%s","quantity_change":-4},{"product_id":"%s","variant_id":"%s","quantity_change":-1},{"product_id":"%s","quantity_change":-2}]}`,
+				product.ID, product.ID, variant.ID, other.ID),
+			expectedStatus: fiber.StatusOK,
+			expectedStock:  [3]int64{6, 2, 0},
+		},
+		{
+			name: "Failure - All or nothing with every failing line",
+			payload: fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity_change":5},{"product_id":"%s","quantity_change":-1},{"product_id":"%s","quantity_change":1},{"product_id":"%s","variant_id":"%s","quantity_change":-3}]}`,
+				product.ID, other.ID, missing, product.ID, variant.ID),
+			expectedStatus:   fiber.StatusConflict,
+			expectedFailures: []int{1, 2, 3},
+			expectedStock:    [3]int64{6, 2, 0},
+		},
+		{
+			name: "Failure - Lines on the same product add up",
+			payload: fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity_change":-4},{"product_id":"%s","quantity_change":-4}]}`,
+				product.ID, product.ID),
+			expectedStatus:   fiber.StatusConflict,
+			expectedFailures: []int{1},
+			expectedStock:    [3]int64{6, 2, 0},
+		},
+		{
+			name:           "Failure - Zero quantity change",
+			payload:        fmt.Sprintf(`{"lines":[{"product_id":"%s","quantity_change":0}]}`, product.ID),
+			expectedStatus: fiber.StatusBadRequest,
+			expectedStock:  [3]int64{6, 2, 0},
+		},
+		{
+			name:           "Failure - No lines",
+			payload:        `{"lines":[]}`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedStock:  [3]int64{6, 2, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := sendJSON(t, app, http.MethodPost, "/api/products/stock/bulk", tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			if tc.expectedFailures != nil {
+				var returned BulkStockErrorResponse
+				assert.NoError(t, json.Unmarshal(body, &returned))
+				indexes := []int{}
+				for _, failure := range returned.Failures {
+					indexes = append(indexes, failure.Index)
+					assert.NotEmpty(t, failure.Error)
+				}
+				assert.Equal(t, tc.expectedFailures, indexes)
+			}
+
+			productStock, _ := stockOf(product.ID)
+			otherStock, _ := stockOf(other.ID)
+			var storedVariant models.Variant
+			database.DB.First(&storedVariant, variant.ID)
+			assert.Equal(t, tc.expectedStock, [3]int64{productStock, storedVariant.Stock, otherStock})
+		})
+	}
+
+	var sales []models.StockMovement
+	database.DB.Where("reference_id = ?", "ORDER-42").Find(&sales)
+	assert.Len(t, sales, 3)
+	var total int64
+	database.DB.Model(&models.StockMovement{}).Count(&total)
+	assert.Equal(t, int64(3), total, "failed requests must not be recorded")
+
+	// The second line only sees the stock left by the first one.
+	status, body := sendJSON(t, app, http.MethodPost, "/api/products/stock/bulk", fmt.Sprintf(
+		`{"lines":[{"product_id":"%s","quantity_change":-4},{"product_id":"%s","quantity_change":-4}]}`, product.ID, product.ID))
+	assert.Equal(t, fiber.StatusConflict, status)
+	var returned BulkStockErrorResponse
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	if assert.Len(t, returned.Failures, 1) {
+		assert.Equal(t, int64(2), *returned.Failures[0].Available)
+		assert.Equal(t, int64(4), *returned.Failures[0].Requested)
+	}
+}
+
+func TestInitialStockMovements(t *testing.T) {
+	app := setupTestApp()
+	resetStockMovements(t)
+
+	status, body := sendJSON(t, app, http.MethodPost, "/api/products", `{"name":"Farinha d'Água","price":1200,"stock":8}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	var product models.Product
+	assert.NoError(t, json.Unmarshal(body, &product))
+
+	status, body = sendJSON(t, app, http.MethodPost, "/api/products/"+product.ID.String()+"/variants", `{"sku":"FARINHA-5KG","price":5000,"stock":2}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	var variant models.Variant
+	assert.NoError(t, json.Unmarshal(body, &variant))
+
+	history := stockHistory(t, app, product.ID, "")
+	assert.Equal(t, int64(2), history.Total)
+	for _, movement := range history.Data {
+		assert.Equal(t, models.MovementRestock, movement.Reason)
+		assert.Equal(t, movement.Delta, movement.Balance)
+	}
+
+	// PATCH leaves the stock to the stock endpoint and its ledger.
+	status, _ = sendJSON(t, app, http.MethodPatch, "/api/products/"+product.ID.String(), `{"name":"Farinha d'Água Fina","stock":100,"Stock":100}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	stock, _ := stockOf(product.ID)
+	assert.Equal(t, int64(8), stock)
+	assert.Equal(t, int64(2), stockHistory(t, app, product.ID, "").Total)
+}
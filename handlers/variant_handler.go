@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"products/database"
+	"products/inventory"
+	"products/models"
+)
+
+type PatchVariantRequest struct {
+	SKU     *string            `json:"sku,omitempty"`
+	Options *map[string]string `json:"options,omitempty"`
+	Price   *int64             `json:"price,omitempty"`
+	Barcode *string            `json:"barcode,omitempty"`
+}
+
+var errInvalidUUID = errors.New("invalid UUID format")
+
+// CreateVariant godoc
+// @Summary      Create a Variant
+// @Description  Add a variant (size, flavour...) with its own SKU, price and stock to a product
+// @Tags         variants
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string          true  "Product ID (UUID)"
+// @Param        variant  body      models.Variant  true  "Data of Variant (ID, ProductID, CreatedAt, UpdatedAt are ignored)"
+// @Success      201      {object}  models.Variant
+// @Failure      400      {object}  map[string]string
+// @Failure      404      {object}  map[string]string
+// @Failure      409      {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/variants [post]
+func CreateVariant(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	variant := new(models.Variant)
+	if err := c.BodyParser(variant); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if variant.SKU == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Variant SKU is required"})
+	}
+	if variant.Price < 0 || variant.Stock < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Variant price and stock cannot be negative"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	var existing int64
+	if err := db.Model(&models.Variant{}).Where("sku = ?", variant.SKU).Count(&existing).Error; err != nil {
+		log.Printf("Error checking variant SKU: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create variant"})
+	}
+	if existing > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A variant with this SKU already exists"})
+	}
+
+	variant.ProductID = productID
+	variant.Reserved = 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(variant).Error; err != nil {
+			return err
+		}
+		if variant.Stock == 0 {
+			return nil
+		}
+		_, err := inventory.RecordMovement(tx, productID, &variant.ID, variant.Stock, variant.Stock, models.MovementRestock, nil)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error creating variant in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create variant"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(variant)
+}
+
+// GetVariants godoc
+// @Summary      List the Variants of a Product
+// @Description  Return every variant of a product
+// @Tags         variants
+// @Produce      json
+// @Param        id   path      string  true  "Product ID (UUID)"
+// @Success      200  {array}   models.Variant
+// @Failure      404  {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /products/{id}/variants [get]
+func GetVariants(c *fiber.Ctx) error {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+
+	if err := db.First(&models.Product{}, productID).Error; err != nil {
+		log.Printf("Error getting product in database: %s", err)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+	}
+
+	variants := []models.Variant{}
+	if err := db.Where("product_id = ?", productID).Order("sku ASC").Find(&variants).Error; err != nil {
+		log.Printf("Error getting variants in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not fetch variants"})
+	}
+	return c.JSON(variants)
+}
+
+// GetVariantByID godoc
+// @Summary      Find variant by id
+// @Description  Return a single variant of a product
+// @Tags         variants
+// @Produce      json
+// @Param        id         path      string  true  "Product ID (UUID)"
+// @Param        variantId  path      string  true  "Variant ID (UUID)"
+// @Success      200        {object}  models.Variant
+// @Failure      404        {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /products/{id}/variants/{variantId} [get]
+func GetVariantByID(c *fiber.Ctx) error {
+	variant, err := findVariant(c)
+	if err != nil {
+		return variantLookupError(c, err)
+	}
+	return c.JSON(variant)
+}
+
+// PatchVariant godoc
+// @Summary      Update a Variant
+// @Description  Update data of a variant. Stock changes go through the stock endpoint.
+// @Tags         variants
+// @Accept       json
+// @Produce      json
+// @Param        id         path      string               true  "Product ID (UUID)"
+// @Param        variantId  path      string               true  "Variant ID (UUID)"
+// @Param        variant    body      PatchVariantRequest  true  "New Variant Data"
+// @Success      200        {object}  models.Variant
+// @Failure      404        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/variants/{variantId} [patch]
+func PatchVariant(c *fiber.Ctx) error {
+	db := database.DB
+	variant, err := findVariant(c)
+	if err != nil {
+		return variantLookupError(c, err)
+	}
+
+	payload := new(PatchVariantRequest)
+	if err := c.BodyParser(payload); err != nil {
+		log.Printf("Error parsing patch request body: %s", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	if payload.SKU != nil && *payload.SKU != variant.SKU {
+		if *payload.SKU == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Variant SKU is required"})
+		}
+		var existing int64
+		if err := db.Model(&models.Variant{}).Where("sku = ?", *payload.SKU).Count(&existing).Error; err != nil {
+			log.Printf("Error checking variant SKU: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update variant"})
+		}
+		if existing > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A variant with this SKU already exists"})
+		}
+		variant.SKU = *payload.SKU
+	}
+	if payload.Options != nil {
+		variant.Options = *payload.Options
+	}
+	if payload.Price != nil {
+		if *payload.Price < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Variant price and stock cannot be negative"})
+		}
+		variant.Price = *payload.Price
+	}
+	if payload.Barcode != nil {
+		variant.Barcode = payload.Barcode
+	}
+
+	if err := db.Save(variant).Error; err != nil {
+		log.Printf("Error updating variant in database: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update variant"})
+	}
+	return c.JSON(variant)
+}
+
+// DeleteVariant godoc
+// @Summary      Delete a Variant
+// @Description  Remove a variant from its product
+// @Tags         variants
+// @Param        id         path      string  true  "Product ID (UUID)"
+// @Param        variantId  path      string  true  "Variant ID (UUID)"
+// @Success      204        {object}  nil
+// @Failure      404        {object}  map[string]string
+// @Failure      409        {object}  map[string]string
+// @Security     ApiKeyAuth
+// @Router       /products/{id}/variants/{variantId} [delete]
+func DeleteVariant(c *fiber.Ctx) error {
+	db := database.DB
+	variant, err := findVariant(c)
+	if err != nil {
+		return variantLookupError(c, err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		reserved, err := inventory.HasPendingReservations(tx, variant.ProductID, &variant.ID)
+		if err != nil {
+			return err
+		}
+		if reserved {
+			return inventory.ErrPendingReservations
+		}
+		return tx.Delete(variant).Error
+	})
+	if errors.Is(err, inventory.ErrPendingReservations) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Variant has pending reservations; release them before deleting it"})
+	}
+	if errors.Is(err, inventory.ErrVariantNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Variant not found"})
+	}
+	if err != nil {
+		log.Printf("Error deleting variant: %s", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete variant"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// findVariant loads the variant addressed by the :id and :variantId route
+// parameters.
+func findVariant(c *fiber.Ctx) (*models.Variant, error) {
+	db := database.DB
+	productID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, errInvalidUUID
+	}
+	variantID, err := uuid.Parse(c.Params("variantId"))
+	if err != nil {
+		return nil, errInvalidUUID
+	}
+
+	variant := new(models.Variant)
+	if err := db.Where("product_id = ?", productID).First(variant, variantID).Error; err != nil {
+		log.Printf("Error getting variant in database: %s", err)
+		return nil, inventory.ErrVariantNotFound
+	}
+	return variant, nil
+}
+
+func variantLookupError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errInvalidUUID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid UUID format"})
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Variant not found"})
+}
+
+// updateVariantStock adjusts the stock of one variant of a product, locking
+// its row for the duration of the transaction just like UpdateStock does for
+// products.
+func updateVariantStock(c *fiber.Ctx, productID uuid.UUID, payload *UpdateStockRequest) error {
+	db := database.DB
+
+	var updatedVariant models.Variant
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var variant models.Variant
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ?", productID).
+			First(&variant, *payload.VariantID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inventory.ErrVariantNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		newStock := variant.Stock + payload.QuantityChange
+		if newStock < variant.Reserved {
+			return &inventory.InsufficientStockError{
+				ProductID: productID,
+				VariantID: &variant.ID,
+				Available: variant.Stock - variant.Reserved,
+				Requested: -payload.QuantityChange,
+			}
+		}
+
+		variant.Stock = newStock
+		if err := tx.Save(&variant).Error; err != nil {
+			return err
+		}
+
+		_, err = inventory.RecordMovement(tx, productID, &variant.ID, payload.QuantityChange, newStock, payload.Reason, payload.ReferenceID)
+		if err != nil {
+			return err
+		}
+
+		updatedVariant = variant
+		return nil
+	})
+	if err != nil {
+		return stockError(c, err, "Could not update stock")
+	}
+	return c.Status(fiber.StatusOK).JSON(updatedVariant)
+}
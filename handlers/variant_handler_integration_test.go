@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"products/database"
+	"products/models"
+	"testing"
+)
+
+func resetVariants(t *testing.T) models.Product {
+	setupTestDB(t)
+	database.DB.Exec("DELETE FROM variants")
+	database.DB.Exec("DELETE FROM products")
+
+	product := models.Product{Name: "Café Torrado", Price: 2000, Stock: 0}
+	database.DB.Create(&product)
+	return product
+}
+
+func TestCreateVariant(t *testing.T) {
+	app := setupTestApp()
+	product := resetVariants(t)
+	database.DB.Create(&models.Variant{ProductID: product.ID, SKU: "CAFE-250", Price: 1200})
+
+	testCases := []struct {
+		name           string
+		productID      string
+		payload        string
+		expectedStatus int
+	}{
+		{
+			name:           "Success - Create Variant",
+			productID:      product.ID.String(),
+			payload:        `{"sku":"CAFE-500","options":{"peso":"500g"},"price":2000,"stock":10,"barcode":"7890000000017"}`,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Failure - Duplicated SKU",
+			productID:      product.ID.String(),
+			payload:        `{"sku":"CAFE-250","price":1200}`,
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Failure - Missing SKU",
+			productID:      product.ID.String(),
+			payload:        `{"price":1200}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Failure - Product not found",
+			productID:      uuid.New().String(),
+			payload:        `{"sku":"CAFE-1KG","price":3500}`,
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPost, "/api/products/"+tc.productID+"/variants", tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+		})
+	}
+
+	status, body := sendJSON(t, app, http.MethodGet, "/api/products/"+product.ID.String(), "")
+	assert.Equal(t, fiber.StatusOK, status)
+	var returned models.Product
+	assert.NoError(t, json.Unmarshal(body, &returned))
+	assert.Len(t, returned.Variants, 2)
+	assert.Equal(t, "CAFE-500", returned.Variants[1].SKU)
+	assert.Equal(t, map[string]string{"peso": "500g"}, returned.Variants[1].Options)
+}
+
+func TestPatchAndDeleteVariant(t *testing.T) {
+	app := setupTestApp()
+	product := resetVariants(t)
+	variant := models.Variant{ProductID: product.ID, SKU: "CAFE-250", Price: 1200, Stock: 4}
+	database.DB.Create(&variant)
+	database.DB.Create(&models.Variant{ProductID: product.ID, SKU: "CAFE-500", Price: 2000})
+	target := fmt.Sprintf("/api/products/%s/variants/%s", product.ID, variant.ID)
+
+	status, body := sendJSON(t, app, http.MethodPatch, target, `{"price":1300,"options":{"peso":"250g"},"stock":99}`)
+	assert.Equal(t, fiber.StatusOK, status)
+	var updated models.Variant
+	assert.NoError(t, json.Unmarshal(body, &updated))
+	assert.Equal(t, int64(1300), updated.Price)
+	assert.Equal(t, int64(4), updated.Stock)
+	assert.Equal(t, map[string]string{"peso": "250g"}, updated.Options)
+
+	status, _ = sendJSON(t, app, http.MethodPatch, target, `{"sku":"CAFE-500"}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+
+	status, _ = sendJSON(t, app, http.MethodGet, fmt.Sprintf("/api/products/%s/variants/%s", uuid.New(), variant.ID), "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+
+	status, _ = sendJSON(t, app, http.MethodDelete, target, "")
+	assert.Equal(t, fiber.StatusNoContent, status)
+
+	status, _ = sendJSON(t, app, http.MethodGet, target, "")
+	assert.Equal(t, fiber.StatusNotFound, status)
+}
+
+func TestUpdateVariantStock(t *testing.T) {
+	app := setupTestApp()
+	product := resetVariants(t)
+	variant := models.Variant{ProductID: product.ID, SKU: "CAFE-250", Price: 1200, Stock: 5}
+	database.DB.Create(&variant)
+	target := fmt.Sprintf("/api/products/%s/stock", product.ID)
+
+	testCases := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		expectedStock  int64
+	}{
+		{
+			name:           "Success - Decrease variant stock",
+			payload:        fmt.Sprintf(`{"variant_id":"%s","quantity_change":-3}`, variant.ID),
+			expectedStatus: fiber.StatusOK,
+			expectedStock:  2,
+		},
+		{
+			name:           "Failure - Insufficient variant stock",
+			payload:        fmt.Sprintf(`{"variant_id":"%s","quantity_change":-3}`, variant.ID),
+			expectedStatus: fiber.StatusConflict,
+			expectedStock:  2,
+		},
+		{
+			name:           "Failure - Variant not found",
+			payload:        fmt.Sprintf(`{"variant_id":"%s","quantity_change":1}`, uuid.New()),
+			expectedStatus: fiber.StatusNotFound,
+			expectedStock:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, _ := sendJSON(t, app, http.MethodPost, target, tc.payload)
+			assert.Equal(t, tc.expectedStatus, status)
+
+			var stored models.Variant
+			database.DB.First(&stored, variant.ID)
+			assert.Equal(t, tc.expectedStock, stored.Stock)
+		})
+	}
+
+	var stored models.Product
+	database.DB.First(&stored, product.ID)
+	assert.Equal(t, int64(0), stored.Stock, "product stock must not change")
+}
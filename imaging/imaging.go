@@ -0,0 +1,126 @@
+// Package imaging validates and normalizes uploaded product images.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"products/config"
+)
+
+type Format string
+
+const (
+	JPEG Format = "jpeg"
+	PNG  Format = "png"
+	WebP Format = "webp"
+)
+
+const (
+	defaultMaxBytes  = 10 << 20
+	defaultMaxWidth  = 6000
+	defaultMaxHeight = 6000
+)
+
+var (
+	ErrUnsupportedFormat  = errors.New("unsupported image format: only JPEG, PNG and WebP are accepted")
+	ErrInvalidImage       = errors.New("invalid image")
+	ErrTooLarge           = errors.New("image is too large")
+	ErrDimensionsTooLarge = errors.New("image dimensions are too large")
+)
+
+// ContentType is the MIME type of images in the format.
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+// Limits bounds the images accepted on upload.
+type Limits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+}
+
+// DefaultLimits reads the upload limits from IMAGE_MAX_BYTES,
+// IMAGE_MAX_WIDTH and IMAGE_MAX_HEIGHT.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBytes:  config.Int64("IMAGE_MAX_BYTES", defaultMaxBytes),
+		MaxWidth:  int(config.Int64("IMAGE_MAX_WIDTH", defaultMaxWidth)),
+		MaxHeight: int(config.Int64("IMAGE_MAX_HEIGHT", defaultMaxHeight)),
+	}
+}
+
+// Image is a validated image, stripped of its metadata.
+type Image struct {
+	Data   []byte
+	Format Format
+	Width  int
+	Height int
+}
+
+// Sniff identifies the format of an image from its magic bytes.
+func Sniff(data []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG, nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG, nil
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return WebP, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Normalize reads an uploaded image and checks it against limits: the content
+// must be a JPEG, PNG or WebP image within the size and dimension limits. EXIF,
+// XMP and text metadata are removed without re-encoding the image, except for
+// JPEG images with an EXIF orientation, which are rotated upright and
+// re-encoded so they keep displaying the same way.
+func Normalize(r io.Reader, limits Limits) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, limits.MaxBytes)
+	}
+
+	format, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	header, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	width, height := header.Width, header.Height
+
+	orientation := 1
+	if format == JPEG {
+		orientation = jpegOrientation(data)
+	}
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if width > limits.MaxWidth || height > limits.MaxHeight {
+		return nil, fmt.Errorf("%w: %dx%d exceeds the limit of %dx%d pixels", ErrDimensionsTooLarge, width, height, limits.MaxWidth, limits.MaxHeight)
+	}
+
+	if orientation > 1 {
+		data, err = orientJPEG(data, orientation)
+	} else {
+		data, err = StripMetadata(format, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+
+	return &Image{Data: data, Format: format, Width: width, Height: height}, nil
+}
@@ -0,0 +1,210 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+)
+
+var testLimits = Limits{MaxBytes: 1 << 20, MaxWidth: 100, MaxHeight: 100}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	return buf.Bytes()
+}
+
+// withEXIF inserts an EXIF segment holding an orientation right after the
+// start of a JPEG image.
+func withEXIF(jpegData []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	payload := append(append(append([]byte("Exif\x00\x00"), tiff...), entry...), 0, 0, 0, 0)
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte(nil), jpegData[:2]...)
+	out = append(out, segment...)
+	return append(out, jpegData[2:]...)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(append(chunk, chunkType...), data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestSniff(t *testing.T) {
+	webp, err := os.ReadFile("testdata/gopher.webp")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected Format
+		err      error
+	}{
+		{name: "JPEG", data: encodeJPEG(t, image.NewGray(image.Rect(0, 0, 1, 1))), expected: JPEG},
+		{name: "PNG", data: encodePNG(t, 1, 1), expected: PNG},
+		{name: "WebP", data: webp, expected: WebP},
+		{name: "PDF", data: []byte("%PDF-1.7\n"), err: ErrUnsupportedFormat},
+		{name: "GIF", data: []byte("GIF89a"), err: ErrUnsupportedFormat},
+		{name: "Empty", data: nil, err: ErrUnsupportedFormat},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			format, err := Sniff(tc.data)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, format)
+		})
+	}
+}
+
+func TestNormalizeLimits(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "Within limits", data: encodePNG(t, 100, 50)},
+		{name: "Too wide", data: encodePNG(t, 101, 50), err: ErrDimensionsTooLarge},
+		{name: "Too many bytes", data: append(encodePNG(t, 1, 1), make([]byte, 1<<20)...), err: ErrTooLarge},
+		{name: "Not an image", data: []byte("%PDF-1.7\n"), err: ErrUnsupportedFormat},
+		{name: "Truncated", data: encodePNG(t, 10, 10)[:20], err: ErrInvalidImage},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Normalize(bytes.NewReader(tc.data), testLimits)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestNormalizeStripsPNGText(t *testing.T) {
+	original := encodePNG(t, 3, 2)
+	text := pngChunk("tEXt", []byte("Comment\x00taken at home"))
+	withText := append(append(append([]byte(nil), original[:33]...), text...), original[33:]...)
+
+	normalized, err := Normalize(bytes.NewReader(withText), testLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, original, normalized.Data)
+	assert.Equal(t, PNG, normalized.Format)
+	assert.Equal(t, []int{3, 2}, []int{normalized.Width, normalized.Height})
+}
+
+func TestNormalizeStripsJPEGExif(t *testing.T) {
+	original := encodeJPEG(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+
+	normalized, err := Normalize(bytes.NewReader(withEXIF(original, 1)), testLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, original, normalized.Data, "the image data is copied untouched")
+	assert.Equal(t, 1, jpegOrientation(normalized.Data))
+}
+
+func TestNormalizeAppliesJPEGOrientation(t *testing.T) {
+	// A 4x2 image, white on the left half, rotated by 90 degrees clockwise
+	// when displayed.
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	normalized, err := Normalize(bytes.NewReader(withEXIF(encodeJPEG(t, img), 6)), testLimits)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, []int{normalized.Width, normalized.Height})
+	assert.Equal(t, 1, jpegOrientation(normalized.Data))
+
+	decoded, err := jpeg.Decode(bytes.NewReader(normalized.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), decoded.Bounds())
+	top, _, _, _ := decoded.At(0, 0).RGBA()
+	bottom, _, _, _ := decoded.At(0, 3).RGBA()
+	assert.Greater(t, top, uint32(0xC000), "the left half ends up on top")
+	assert.Less(t, bottom, uint32(0x4000))
+}
+
+func TestNormalizeStripsWebPExif(t *testing.T) {
+	simple, err := os.ReadFile("testdata/gopher.webp")
+	assert.NoError(t, err)
+	header, err := Normalize(bytes.NewReader(simple), Limits{MaxBytes: 1 << 20, MaxWidth: 1000, MaxHeight: 1000})
+	assert.NoError(t, err)
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = vp8xFlagEXIF
+	copy(vp8x[4:], []byte{byte(header.Width - 1), byte((header.Width - 1) >> 8), 0})
+	copy(vp8x[7:], []byte{byte(header.Height - 1), byte((header.Height - 1) >> 8), 0})
+	body := append([]byte("WEBP"), riffChunk("VP8X", vp8x)...)
+	body = append(body, simple[12:]...)
+	body = append(body, riffChunk("EXIF", []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00secret"))...)
+	extended := append(riffChunk("RIFF", body)[:8], body...)
+
+	stripped, err := StripMetadata(WebP, extended)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stripped), "EXIF")
+	assert.Equal(t, byte(0), stripped[20]&vp8xFlagEXIF)
+	assert.Equal(t, uint32(len(stripped)-8), binary.LittleEndian.Uint32(stripped[4:]))
+
+	_, err = Normalize(bytes.NewReader(stripped), Limits{MaxBytes: 1 << 20, MaxWidth: 1000, MaxHeight: 1000})
+	assert.NoError(t, err, "the stripped image still decodes")
+}
+
+func TestRenditions(t *testing.T) {
+	opaque := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xFF
+	}
+	var opaquePNG bytes.Buffer
+	assert.NoError(t, png.Encode(&opaquePNG, opaque))
+
+	renditions, err := Renditions(&Image{Data: opaquePNG.Bytes(), Format: PNG, Width: 1000, Height: 500}, Sizes)
+	assert.NoError(t, err)
+	assert.NotContains(t, renditions, "large", "images are never scaled up")
+	if assert.Contains(t, renditions, "thumbnail") {
+		assert.Equal(t, []int{150, 75}, []int{renditions["thumbnail"].Width, renditions["thumbnail"].Height})
+		assert.Equal(t, JPEG, renditions["thumbnail"].Format, "opaque images are encoded as JPEG")
+		format, err := Sniff(renditions["thumbnail"].Data)
+		assert.NoError(t, err)
+		assert.Equal(t, JPEG, format)
+	}
+
+	transparent := encodePNG(t, 300, 600)
+	renditions, err = Renditions(&Image{Data: transparent, Format: PNG, Width: 300, Height: 600}, Sizes)
+	assert.NoError(t, err)
+	assert.NotContains(t, renditions, "large")
+	assert.Equal(t, []int{200, 400}, []int{renditions["small"].Width, renditions["small"].Height})
+	assert.Equal(t, []int{75, 150}, []int{renditions["thumbnail"].Width, renditions["thumbnail"].Height})
+	assert.Equal(t, PNG, renditions["thumbnail"].Format, "transparency is kept")
+}
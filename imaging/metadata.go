@@ -0,0 +1,253 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+const reencodeQuality = 90
+
+var errMalformed = errors.New("malformed image structure")
+
+// StripMetadata removes EXIF, XMP, IPTC and text metadata from an image by
+// dropping the segments or chunks that hold them. The image data itself is
+// copied untouched, so no quality is lost. Colour profiles are kept.
+func StripMetadata(format Format, data []byte) ([]byte, error) {
+	switch format {
+	case JPEG:
+		return stripJPEG(data)
+	case PNG:
+		return stripPNG(data)
+	case WebP:
+		return stripWebP(data)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// stripJPEG keeps the JFIF (APP0), ICC profile (APP2) and Adobe (APP14)
+// segments and drops every other application segment and comment.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker.
+			i++
+			continue
+		}
+		if marker == 0xDA {
+			// Start of scan: the entropy-coded data and everything after it
+			// is copied as is.
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+		isMetadata := marker == 0xFE || (marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE)
+		if !isMetadata {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:8])
+
+	for i := 8; i < len(data); {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errMalformed
+		}
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+const (
+	vp8xFlagXMP  = 0x04
+	vp8xFlagEXIF = 0x08
+)
+
+// stripWebP drops the EXIF and XMP chunks of an extended WebP file and clears
+// their flags in the VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) {
+			return nil, errMalformed
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				chunk[8] &^= vp8xFlagEXIF | vp8xFlagXMP
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+	return stripped, nil
+}
+
+// jpegOrientation returns the EXIF orientation (1 to 8) of a JPEG image, or 1
+// when it has none.
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		payload := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifOrientation(payload[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientJPEG rotates and flips a JPEG image according to its EXIF orientation
+// and re-encodes it without metadata.
+func orientJPEG(data []byte, orientation int) ([]byte, error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, orient(decoded, orientation), &jpeg.Options{Quality: reencodeQuality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// orient applies an EXIF orientation to img, returning it upright.
+func orient(img image.Image, orientation int) *image.NRGBA {
+	bounds := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
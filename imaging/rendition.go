@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"sort"
+)
+
+const renditionQuality = 85
+
+// Size is a named rendition size: images are scaled down so their longest
+// side is at most MaxSide pixels.
+type Size struct {
+	Name    string
+	MaxSide int
+}
+
+// Sizes are the renditions generated for product images.
+var Sizes = []Size{
+	{Name: "thumbnail", MaxSide: 150},
+	{Name: "small", MaxSide: 400},
+	{Name: "large", MaxSide: 1200},
+}
+
+// Renditions scales img down to each of sizes. Sizes the image already fits in
+// are left out, since the original can be used as is. Renditions are JPEG
+// images, or PNG when the image has transparency: the standard library and
+// x/image can decode WebP but not encode it.
+func Renditions(img *Image, sizes []Size) (map[string]*Image, error) {
+	source, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+	transparent := !isOpaque(source)
+
+	// Scale from the largest size down, each rendition being the source of
+	// the next smaller one, which is much cheaper than always starting from
+	// the original.
+	sorted := append([]Size(nil), sizes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MaxSide > sorted[j].MaxSide })
+
+	renditions := make(map[string]*Image, len(sizes))
+	for _, size := range sorted {
+		width, height := fit(source.Bounds().Dx(), source.Bounds().Dy(), size.MaxSide)
+		if width == source.Bounds().Dx() && height == source.Bounds().Dy() {
+			continue
+		}
+
+		scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), source, source.Bounds(), draw.Src, nil)
+		source = scaled
+
+		rendition := &Image{Width: width, Height: height}
+		var out bytes.Buffer
+		if transparent {
+			rendition.Format = PNG
+			err = png.Encode(&out, scaled)
+		} else {
+			rendition.Format = JPEG
+			err = jpeg.Encode(&out, scaled, &jpeg.Options{Quality: renditionQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+		rendition.Data = out.Bytes()
+		renditions[size.Name] = rendition
+	}
+	return renditions, nil
+}
+
+// fit scales width and height down, keeping the aspect ratio, so the longest
+// side is at most maxSide.
+func fit(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+	return false
+}
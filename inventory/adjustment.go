@@ -0,0 +1,112 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"products/models"
+	"sort"
+	"strings"
+)
+
+// Adjustment is a change to the stock of a product, or of one of its
+// variants.
+type Adjustment struct {
+	ProductID      uuid.UUID
+	VariantID      *uuid.UUID
+	QuantityChange int64
+}
+
+// AdjustmentFailure is an adjustment that could not be applied, identified by
+// its position in the request.
+type AdjustmentFailure struct {
+	Index int
+	Err   error
+}
+
+// AdjustmentError lists every adjustment that failed. When it is returned no
+// adjustment has been applied.
+type AdjustmentError struct {
+	Failures []AdjustmentFailure
+}
+
+func (e *AdjustmentError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		messages = append(messages, fmt.Sprintf("line %d: %s", failure.Index, failure.Err))
+	}
+	return "stock adjustment failed: " + strings.Join(messages, "; ")
+}
+
+// AdjustStock applies every adjustment in one transaction and records each of
+// them in the ledger with reason and referenceID. Rows are locked in product
+// and variant order so concurrent calls cannot deadlock. Either every
+// adjustment is applied or none is; in the latter case an *AdjustmentError
+// describes all the lines that failed. The recorded movements are returned in
+// the order of adjustments.
+func AdjustStock(db *gorm.DB, adjustments []Adjustment, reason string, referenceID *string) ([]models.StockMovement, error) {
+	movements := make([]models.StockMovement, len(adjustments))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var failures []AdjustmentFailure
+
+		for _, index := range sortedAdjustments(adjustments) {
+			adjustment := adjustments[index]
+			level, err := lockStockLevel(tx, adjustment.ProductID, adjustment.VariantID)
+			if errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrVariantNotFound) {
+				failures = append(failures, AdjustmentFailure{Index: index, Err: err})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			newStock := level.Stock + adjustment.QuantityChange
+			if newStock < level.Reserved {
+				err := insufficientStock(adjustment.ProductID, adjustment.VariantID, level, -adjustment.QuantityChange)
+				failures = append(failures, AdjustmentFailure{Index: index, Err: err})
+				continue
+			}
+
+			// Successful lines are applied even after a failure, so later lines
+			// on the same row are checked against the cumulative stock; the
+			// transaction is rolled back at the end.
+			err = adjustStockLevel(tx, adjustment.ProductID, adjustment.VariantID, adjustment.QuantityChange, 0)
+			if err != nil {
+				return err
+			}
+			movements[index], err = RecordMovement(tx, adjustment.ProductID, adjustment.VariantID, adjustment.QuantityChange, newStock, reason, referenceID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(failures) > 0 {
+			sort.Slice(failures, func(i, j int) bool { return failures[i].Index < failures[j].Index })
+			return &AdjustmentError{Failures: failures}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// sortedAdjustments returns the indexes of adjustments in the order their rows
+// are locked in, like sortedLines does for reservations.
+func sortedAdjustments(adjustments []Adjustment) []int {
+	indexes := make([]int, len(adjustments))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, b := adjustments[indexes[i]], adjustments[indexes[j]]
+		if a.ProductID != b.ProductID {
+			return a.ProductID.String() < b.ProductID.String()
+		}
+		return variantKey(a.VariantID) < variantKey(b.VariantID)
+	})
+	return indexes
+}
@@ -0,0 +1,47 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrProductNotFound     = errors.New("product not found")
+	ErrVariantNotFound     = errors.New("variant not found")
+	ErrInsufficientStock   = errors.New("insufficient stock")
+	ErrReservationNotFound = errors.New("reservation not found")
+	ErrReservationExpired  = errors.New("reservation has expired")
+	ErrReservationClosed   = errors.New("reservation is no longer pending")
+	ErrPendingReservations = errors.New("stock is held by pending reservations")
+)
+
+// InsufficientStockError is returned when a product or variant does not have
+// enough unreserved stock for a change. It matches ErrInsufficientStock with
+// errors.Is.
+type InsufficientStockError struct {
+	ProductID uuid.UUID
+	VariantID *uuid.UUID
+	// Available is the stock not held by reservations, Requested the quantity
+	// that was asked for.
+	Available int64
+	Requested int64
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for product %s: %d available, %d requested", e.ProductID, e.Available, e.Requested)
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+// insufficientStock builds the error for taking requested units out of level.
+func insufficientStock(productID uuid.UUID, variantID *uuid.UUID, level stockLevel, requested int64) error {
+	return &InsufficientStockError{
+		ProductID: productID,
+		VariantID: variantID,
+		Available: level.Stock - level.Reserved,
+		Requested: requested,
+	}
+}
@@ -0,0 +1,22 @@
+package inventory
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"products/models"
+)
+
+// RecordMovement appends a change of stock to the ledger. It must run in the
+// transaction that changed the stock, so both are committed together.
+func RecordMovement(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, delta, balance int64, reason string, referenceID *string) (models.StockMovement, error) {
+	movement := models.StockMovement{
+		ProductID:   productID,
+		VariantID:   variantID,
+		Delta:       delta,
+		Balance:     balance,
+		Reason:      reason,
+		ReferenceID: referenceID,
+	}
+	err := tx.Create(&movement).Error
+	return movement, err
+}
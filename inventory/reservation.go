@@ -0,0 +1,236 @@
+package inventory
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"products/config"
+	"products/models"
+	"sort"
+	"time"
+)
+
+const defaultReservationTTL = 15 * time.Minute
+
+// ReservationTTL is how long a reservation holds stock before the reaper
+// releases it, configured through RESERVATION_TTL.
+func ReservationTTL() time.Duration {
+	return config.Duration("RESERVATION_TTL", defaultReservationTTL)
+}
+
+// Reserve holds the quantities of lines until the returned reservation is
+// confirmed, released or expires after ttl. Either every line is reserved or
+// none is.
+func Reserve(db *gorm.DB, lines []models.ReservationLine, ttl time.Duration) (*models.Reservation, error) {
+	reservation := &models.Reservation{
+		Status:    models.ReservationPending,
+		ExpiresAt: time.Now().Add(ttl),
+		Lines:     lines,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, line := range sortedLines(lines) {
+			level, err := lockStockLevel(tx, line.ProductID, line.VariantID)
+			if err != nil {
+				return err
+			}
+			if level.Stock-level.Reserved < line.Quantity {
+				return insufficientStock(line.ProductID, line.VariantID, level, line.Quantity)
+			}
+			if err := adjustStockLevel(tx, line.ProductID, line.VariantID, 0, line.Quantity); err != nil {
+				return err
+			}
+		}
+		return tx.Create(reservation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// Confirm turns a pending reservation into a sale: the reserved quantities are
+// removed from stock and recorded in the ledger as sales referencing the
+// reservation. Reservations past their expiry are expired instead and
+// ErrReservationExpired is returned.
+func Confirm(db *gorm.DB, id uuid.UUID) (*models.Reservation, error) {
+	return closeReservation(db, id, models.ReservationConfirmed, true)
+}
+
+// Release cancels a pending reservation, making its quantities available again.
+func Release(db *gorm.DB, id uuid.UUID) (*models.Reservation, error) {
+	return closeReservation(db, id, models.ReservationReleased, false)
+}
+
+// ExpireReservations releases every pending reservation whose expiry is at or
+// before now, returning how many were expired. A reservation that cannot be
+// expired is logged and skipped, so it does not hold back the others.
+func ExpireReservations(db *gorm.DB, now time.Time) (int, error) {
+	var ids []uuid.UUID
+	err := db.Model(&models.Reservation{}).
+		Where("status = ? AND expires_at <= ?", models.ReservationPending, now).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, id := range ids {
+		_, err := closeReservation(db, id, models.ReservationExpired, false)
+		if errors.Is(err, ErrReservationClosed) {
+			// Confirmed or released concurrently.
+			continue
+		}
+		if err != nil {
+			log.Printf("Error expiring reservation %s: %s", id, err)
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// HasPendingReservations reports whether pending reservations hold stock of
+// a product, or of one of its variants when variantID is set. It locks the
+// stock rows reservations lock first, the product and its variants or the
+// variant, so that no reservation can be made for them until the transaction
+// tx ends.
+func HasPendingReservations(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID) (bool, error) {
+	if _, err := lockStockLevel(tx, productID, variantID); err != nil {
+		return false, err
+	}
+	if variantID == nil {
+		var variants []models.Variant
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("product_id = ?", productID).Find(&variants).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	query := tx.Model(&models.ReservationLine{}).
+		Joins("JOIN reservations ON reservations.id = reservation_lines.reservation_id").
+		Where("reservations.status = ? AND reservation_lines.product_id = ?", models.ReservationPending, productID)
+	if variantID != nil {
+		query = query.Where("reservation_lines.variant_id = ?", *variantID)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+func closeReservation(db *gorm.DB, id uuid.UUID, status string, consume bool) (*models.Reservation, error) {
+	var reservation models.Reservation
+	expired := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Lines").First(&reservation, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReservationNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if reservation.Status != models.ReservationPending {
+			return ErrReservationClosed
+		}
+
+		if consume && !reservation.ExpiresAt.After(time.Now()) {
+			status, consume, expired = models.ReservationExpired, false, true
+		}
+
+		reference := reservation.ID.String()
+		for _, line := range sortedLines(reservation.Lines) {
+			level, err := lockStockLevel(tx, line.ProductID, line.VariantID)
+			if !consume && (errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrVariantNotFound)) {
+				// Nothing is left to release for a deleted product or variant.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			stockDelta := int64(0)
+			if consume {
+				stockDelta = -line.Quantity
+			}
+			if err := adjustStockLevel(tx, line.ProductID, line.VariantID, stockDelta, -line.Quantity); err != nil {
+				return err
+			}
+			if consume {
+				_, err := RecordMovement(tx, line.ProductID, line.VariantID, stockDelta, level.Stock+stockDelta, models.MovementSale, &reference)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		reservation.Status = status
+		return tx.Model(&models.Reservation{}).Where("id = ?", reservation.ID).Update("status", status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return &reservation, ErrReservationExpired
+	}
+	return &reservation, nil
+}
+
+type stockLevel struct {
+	Stock    int64
+	Reserved int64
+}
+
+// lockStockLevel locks the row holding the stock of a product, or of one of
+// its variants, until the end of the transaction.
+func lockStockLevel(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID) (stockLevel, error) {
+	locked := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+
+	if variantID != nil {
+		var variant models.Variant
+		err := locked.Where("product_id = ?", productID).First(&variant, *variantID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return stockLevel{}, ErrVariantNotFound
+		}
+		return stockLevel{Stock: variant.Stock, Reserved: variant.Reserved}, err
+	}
+
+	var product models.Product
+	err := locked.First(&product, productID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return stockLevel{}, ErrProductNotFound
+	}
+	return stockLevel{Stock: product.Stock, Reserved: product.Reserved}, err
+}
+
+func adjustStockLevel(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, stockDelta, reservedDelta int64) error {
+	updates := map[string]interface{}{
+		"stock":    gorm.Expr("stock + ?", stockDelta),
+		"reserved": gorm.Expr("reserved + ?", reservedDelta),
+	}
+	if variantID != nil {
+		return tx.Model(&models.Variant{}).Where("id = ?", *variantID).Updates(updates).Error
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).Updates(updates).Error
+}
+
+// sortedLines returns lines ordered by product and variant, the order rows are
+// locked in, so concurrent transactions cannot deadlock each other.
+func sortedLines(lines []models.ReservationLine) []models.ReservationLine {
+	sorted := append([]models.ReservationLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].ProductID != sorted[j].ProductID {
+			return sorted[i].ProductID.String() < sorted[j].ProductID.String()
+		}
+		return variantKey(sorted[i].VariantID) < variantKey(sorted[j].VariantID)
+	})
+	return sorted
+}
+
+func variantKey(variantID *uuid.UUID) string {
+	if variantID == nil {
+		return ""
+	}
+	return variantID.String()
+}
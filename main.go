@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/swagger"
+	"os"
+	"products/auth"
+	"products/config"
 	"products/database"
 	_ "products/docs"
 	"products/handlers"
 	"products/middleware"
+	"products/storage"
+	"products/workers"
+	"strings"
 )
 
 // @title Product API - Sabor da Rondônia
@@ -19,31 +28,106 @@ import (
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT issued by the main API, as "Bearer <token>". Accepted by the product read endpoints.
 func main() {
 	database.Connect()
+	storage.Setup()
+
+	workers.StartReservationReaper(context.Background())
+	workers.StartImageGC(context.Background())
+	workers.StartImageDeletionWorker(context.Background())
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		// Bodies over the default limit are streamed so the image upload
+		// routes can accept them; every other route refuses them.
+		StreamRequestBody: true,
+		// Behind a load balancer, the header holding the client IP, such as
+		// X-Forwarded-For, so failed authentications are counted per client.
+		// It is only read from the proxies listed in TRUSTED_PROXIES.
+		ProxyHeader:             os.Getenv("PROXY_HEADER"),
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          config.List("TRUSTED_PROXIES"),
+	})
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*", // Permite todas as origens
-		AllowHeaders: "Origin, Content-Type, Accept, X-API-KEY",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-API-KEY, Idempotency-Key, X-Signature, X-Timestamp, X-Nonce",
+	}))
+
+	app.Use(logger.New(logger.Config{
+		Format: "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${locals:" + middleware.CredentialKey + "} | ${error}\n",
 	}))
 
-	app.Use(logger.New())
+	if local, ok := storage.Images.(*storage.LocalStore); ok && strings.HasPrefix(local.BaseURL, "/") {
+		app.Static(local.BaseURL, local.Dir)
+	}
 
 	api := app.Group("/api")
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
+	// The image upload routes are registered before the default body limit,
+	// which they replace with their own, checked before the body is read to
+	// authenticate the request.
+	api.Post("/products/:id/upload", middleware.BodyLimit(handlers.ImageBodyLimit()), middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeImagesWrite), handlers.UploadProductImage)
+	api.Post("/products/:id/images", middleware.BodyLimit(handlers.UploadBodyLimit()), middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeImagesWrite), handlers.UploadProductImages)
+	// Direct uploads to the local storage are authorized by their signed URL.
+	api.Post("/uploads/:token", middleware.BodyLimit(handlers.ImageBodyLimit()), handlers.ReceiveDirectUpload)
+
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit))
+
 	productGroup := api.Group("/products", middleware.AuthMiddleware())
 
-	productGroup.Post("/", handlers.CreateProduct)
-	productGroup.Get("/", handlers.GetProducts)
-	productGroup.Get("/:id", handlers.GetProductByID)
-	productGroup.Patch("/:id", handlers.PatchProduct)
-	productGroup.Delete("/:id", handlers.DeleteProduct)
-	productGroup.Post("/:id/upload", handlers.UploadProductImage)
-	productGroup.Post("/batch", handlers.GetProductsByIDs)
-	productGroup.Post("/:id/stock", handlers.UpdateStock)
+	productGroup.Post("/", middleware.RequireScope(auth.ScopeProductsWrite), middleware.Idempotency(), handlers.CreateProduct)
+	productGroup.Get("/", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetProducts)
+	productGroup.Get("/search", middleware.RequireScope(auth.ScopeProductsRead), handlers.SearchProducts)
+	productGroup.Post("/stock/bulk", middleware.RequireScope(auth.ScopeStockWrite), middleware.Idempotency(), handlers.BulkUpdateStock)
+	productGroup.Get("/:id", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetProductByID)
+	productGroup.Patch("/:id", middleware.RequireScope(auth.ScopeProductsWrite), handlers.PatchProduct)
+	productGroup.Delete("/:id", middleware.RequireScope(auth.ScopeProductsWrite), handlers.DeleteProduct)
+	productGroup.Delete("/:id/image", middleware.RequireScope(auth.ScopeImagesWrite), handlers.DeletePrimaryImage)
+	productGroup.Post("/batch", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetProductsByIDs)
+	productGroup.Post("/:id/stock", middleware.RequireScope(auth.ScopeStockWrite), middleware.Idempotency(), handlers.UpdateStock)
+	productGroup.Get("/:id/stock/history", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetStockHistory)
+	productGroup.Put("/:id/categories", middleware.RequireScope(auth.ScopeProductsWrite), handlers.SetProductCategories)
+	productGroup.Post("/:id/images/import", middleware.RequireScope(auth.ScopeImagesWrite), handlers.ImportProductImage)
+	productGroup.Post("/:id/images/uploads", middleware.RequireScope(auth.ScopeImagesWrite), handlers.CreateImageUploadTicket)
+	productGroup.Post("/:id/images/uploads/complete", middleware.RequireScope(auth.ScopeImagesWrite), handlers.CompleteImageUpload)
+	productGroup.Get("/:id/images", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetProductImages)
+	productGroup.Put("/:id/images/order", middleware.RequireScope(auth.ScopeImagesWrite), handlers.ReorderProductImages)
+	productGroup.Patch("/:id/images/:imageId", middleware.RequireScope(auth.ScopeImagesWrite), handlers.PatchProductImage)
+	productGroup.Delete("/:id/images/:imageId", middleware.RequireScope(auth.ScopeImagesWrite), handlers.DeleteProductImage)
+	productGroup.Post("/:id/variants", middleware.RequireScope(auth.ScopeProductsWrite), handlers.CreateVariant)
+	productGroup.Get("/:id/variants", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetVariants)
+	productGroup.Get("/:id/variants/:variantId", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetVariantByID)
+	productGroup.Patch("/:id/variants/:variantId", middleware.RequireScope(auth.ScopeProductsWrite), handlers.PatchVariant)
+	productGroup.Delete("/:id/variants/:variantId", middleware.RequireScope(auth.ScopeProductsWrite), handlers.DeleteVariant)
+
+	reservationGroup := api.Group("/reservations", middleware.AuthMiddleware())
+
+	reservationGroup.Post("/", middleware.RequireScope(auth.ScopeStockWrite), handlers.CreateReservation)
+	reservationGroup.Get("/:id", middleware.RequireScope(auth.ScopeStockWrite), handlers.GetReservationByID)
+	reservationGroup.Post("/:id/confirm", middleware.RequireScope(auth.ScopeStockWrite), handlers.ConfirmReservation)
+	reservationGroup.Post("/:id/release", middleware.RequireScope(auth.ScopeStockWrite), handlers.ReleaseReservation)
+
+	adminGroup := api.Group("/admin", middleware.AuthMiddleware())
+
+	adminGroup.Get("/metrics", middleware.RequireScope(auth.ScopeAdmin), adaptor.HTTPHandler(expvar.Handler()))
+	adminGroup.Get("/image-deletions", middleware.RequireScope(auth.ScopeAdmin), handlers.GetImageDeletionQueue)
+	adminGroup.Post("/api-keys", middleware.RequireScope(auth.ScopeAdmin), handlers.CreateAPIKey)
+	adminGroup.Get("/api-keys", middleware.RequireScope(auth.ScopeAdmin), handlers.GetAPIKeys)
+	adminGroup.Post("/api-keys/:id/rotate", middleware.RequireScope(auth.ScopeAdmin), handlers.RotateAPIKey)
+	adminGroup.Delete("/api-keys/:id", middleware.RequireScope(auth.ScopeAdmin), handlers.RevokeAPIKey)
+
+	categoryGroup := api.Group("/categories", middleware.AuthMiddleware())
+
+	categoryGroup.Post("/", middleware.RequireScope(auth.ScopeProductsWrite), handlers.CreateCategory)
+	categoryGroup.Get("/", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetCategories)
+	categoryGroup.Get("/:id", middleware.RequireScope(auth.ScopeProductsRead), handlers.GetCategoryByID)
+	categoryGroup.Patch("/:id", middleware.RequireScope(auth.ScopeProductsWrite), handlers.PatchCategory)
+	categoryGroup.Delete("/:id", middleware.RequireScope(auth.ScopeProductsWrite), handlers.DeleteCategory)
 
 	app.Listen(":3000")
 }
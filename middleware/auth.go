@@ -1,19 +1,162 @@
 package middleware
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
-	"os"
+	"log"
+	"math"
+	"net/http"
+	"products/auth"
+	"products/config"
+	"products/database"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	SignatureHeader = "X-Signature"
+	KeyIDHeader     = "X-Key-ID"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+
+	principalKey = "principal"
+	// CredentialKey holds the name of the key used by the caller, for the
+	// request log.
+	CredentialKey = "credential"
+)
+
+var errUnsignedSecret = errors.New("requests using the API secret must be signed")
+
+// AuthMiddleware authenticates the caller by its X-API-Key header, bearer
+// token or the signature of the request, see authenticate, and makes it
+// available to RequireScope and the handlers through Principal. Callers using
+// a key that expires get a Sunset header with its expiry, and callers using a
+// rotated key a Deprecation header, so they can move to the new key in time.
+// Clients sending too many invalid credentials are locked out for a while, see
+// failureTracker.
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		apikey := c.Get("X-API-Key")
+		client := clientIP(c)
+		if until := authFailures.lockedUntil(client, time.Now()); !until.IsZero() {
+			authBlockedTotal.Add(1)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many failed authentication attempts"})
+		}
+
+		principal, err := authenticate(c)
+		if errors.Is(err, auth.ErrTokenExpired) || errors.Is(err, auth.ErrTokenNotAccepted) {
+			// Validly signed tokens are not guesses, so they do not count
+			// toward the lockout.
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		if message, failed := authFailureMessage(err); failed {
+			authFailuresTotal.Add(1)
+			count, until := authFailures.fail(client, time.Now())
+			if !until.IsZero() {
+				authLockoutsTotal.Add(1)
+				log.Printf("Locked out %s until %s after %d failed authentications", client, until.Format(time.RFC3339), count)
+			} else {
+				log.Printf("Failed authentication from %s (%d failures): %s", client, count, err)
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": message})
+		}
+		if err != nil {
+			log.Printf("Error authenticating API key: %s", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not authenticate request"})
+		}
 
-		secretKey := os.Getenv("API_SECRET_KEY")
+		c.Locals(principalKey, principal)
+		c.Locals(CredentialKey, principal.Credential)
+		if principal.ExpiresAt != nil {
+			c.Set("Sunset", principal.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+		if principal.DeprecatedAt != nil {
+			// RFC 9745: the time the credential was deprecated, as @<unix time>.
+			c.Set("Deprecation", "@"+strconv.FormatInt(principal.DeprecatedAt.Unix(), 10))
+			log.Printf("%s %s authenticated with retiring key %s", c.Method(), c.Path(), principal.Credential)
+		}
+		return c.Next()
+	}
+}
 
-		if apikey == "" || apikey != secretKey {
+// clientIP returns the address failed authentications are counted against:
+// the address of the peer, or behind a trusted proxy the last address of the
+// proxy header, which that proxy appended. Earlier addresses are sent by the
+// client and can be forged.
+func clientIP(c *fiber.Ctx) string {
+	header := c.App().Config().ProxyHeader
+	if header == "" || !c.IsProxyTrusted() {
+		return c.Context().RemoteIP().String()
+	}
+	forwarded := c.Get(header)
+	if ip := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); ip != "" {
+		return ip
+	}
+	return c.Context().RemoteIP().String()
+}
+
+// authenticate verifies the JWT of requests sent with an Authorization: Bearer
+// header, the signature of requests sent with an X-Signature header, and the
+// X-API-Key header of other requests. With AUTH_REQUIRE_SIGNATURE set, the
+// shared API secret can only be used to sign requests, never sent.
+func authenticate(c *fiber.Ctx) (*auth.Principal, error) {
+	if scheme, token, found := strings.Cut(c.Get(fiber.HeaderAuthorization), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return auth.AuthenticateToken(strings.TrimSpace(token), time.Now())
+	}
+	if signature := c.Get(SignatureHeader); signature != "" {
+		return auth.VerifySignature(database.DB, auth.SignedRequest{
+			KeyID:     c.Get(KeyIDHeader),
+			Method:    c.Method(),
+			Path:      c.OriginalURL(),
+			Timestamp: c.Get(TimestampHeader),
+			Nonce:     c.Get(NonceHeader),
+			Body:      c.Body(),
+			Signature: signature,
+		}, time.Now())
+	}
+
+	principal, err := auth.Authenticate(database.DB, c.Get("X-API-Key"))
+	if err == nil && principal.Subject == auth.SharedSecretSubject && config.Bool("AUTH_REQUIRE_SIGNATURE", false) {
+		return nil, errUnsignedSecret
+	}
+	return principal, err
+}
+
+// authFailureMessage tells whether err means the caller sent invalid
+// credentials, and what to answer.
+func authFailureMessage(err error) (string, bool) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidKey):
+		return "Unauthorized", true
+	case errors.Is(err, auth.ErrInvalidSignature),
+		errors.Is(err, auth.ErrStaleTimestamp),
+		errors.Is(err, auth.ErrInvalidNonce),
+		errors.Is(err, auth.ErrReplayedNonce),
+		errors.Is(err, errUnsignedSecret),
+		errors.Is(err, auth.ErrInvalidToken):
+		return err.Error(), true
+	}
+	return "", false
+}
+
+// RequireScope lets the request through only when the authenticated caller
+// was granted scope. It must run after AuthMiddleware.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := Principal(c)
+		if principal == nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 		}
+		if !principal.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Missing scope " + scope})
+		}
 		return c.Next()
 	}
 }
+
+// Principal returns the caller authenticated by AuthMiddleware, or nil.
+func Principal(c *fiber.Ctx) *auth.Principal {
+	principal, _ := c.Locals(principalKey).(*auth.Principal)
+	return principal
+}
@@ -1,35 +1,169 @@
 package middleware
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/glebarez/sqlite"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 	"io"
 	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"products/auth"
+	"products/database"
+	"products/models"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
+// issueKey stores an API key granted scopes and returns it.
+func issueKey(t *testing.T, name string, scopes []string, expiresAt, revokedAt *time.Time) string {
+	key, hash, err := auth.GenerateKey()
+	assert.NoError(t, err)
+	err = database.DB.Create(&models.APIKey{
+		Name:      name,
+		Prefix:    auth.DisplayPrefix(key),
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		RevokedAt: revokedAt,
+	}).Error
+	assert.NoError(t, err)
+	return key
+}
+
+// issueSigningKey stores an API key granted scopes that can sign requests
+// with signingSecret.
+func issueSigningKey(t *testing.T, name string, scopes []string, signingSecret string) models.APIKey {
+	key, hash, err := auth.GenerateKey()
+	assert.NoError(t, err)
+	apiKey := models.APIKey{
+		Name:          name,
+		Prefix:        auth.DisplayPrefix(key),
+		KeyHash:       hash,
+		SigningSecret: signingSecret,
+		Scopes:        scopes,
+	}
+	assert.NoError(t, database.DB.Create(&apiKey).Error)
+	return apiKey
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	const secrectKey = "test-secret-key"
 
 	t.Setenv("API_SECRET_KEY", secrectKey)
+	authFailures = newFailureTracker()
+
+	sunset := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rotatedAt := time.Date(2026, time.March, 2, 9, 30, 0, 0, time.UTC)
+	deprecated := "^@" + strconv.FormatInt(rotatedAt.Unix(), 10) + "$"
+	t.Setenv("API_SECRET_KEY_ROTATED_AT", rotatedAt.Format(time.RFC3339))
+	t.Setenv("API_PREVIOUS_KEYS", "old-secret-key@"+sunset.Format(time.RFC3339)+
+		", retired-secret-key@2020-01-01T00:00:00Z,oldest-secret-key,p@ss@word@"+sunset.Format(time.RFC3339)+",user@example")
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.APIKey{}); err != nil {
+		t.Fatalf("failed to auto migrate API keys: %v", err)
+	}
+	database.DB = db
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	readKey := issueKey(t, "storefront", []string{auth.ScopeProductsRead}, &future, nil)
+	writeKey := issueKey(t, "backoffice", []string{auth.ScopeProductsRead, auth.ScopeProductsWrite}, nil, nil)
+	expiredKey := issueKey(t, "old storefront", []string{auth.ScopeProductsWrite}, &past, nil)
+	revokedKey := issueKey(t, "leaked", []string{auth.ScopeProductsWrite}, nil, &past)
+	rotatedKey := issueKey(t, "storefront", []string{auth.ScopeProductsRead}, &future, nil)
+	replacement := uuid.New()
+	db.Model(&models.APIKey{}).Where("key_hash = ?", auth.HashKey(rotatedKey)).
+		Updates(map[string]interface{}{"replaced_by_id": replacement, "rotated_at": past})
+
 	testCases := []struct {
 		name           string
+		path           string
 		apiKeyHeader   string
 		expectedStatus int
 		expectedBody   string
 		isJSON         bool
+		// deprecation matches the Deprecation header, which is absent when
+		// it is empty.
+		deprecation string
+		sunset      string
 	}{
 		{
 			name:           "Success - Correct Key",
+			path:           "/write",
 			apiKeyHeader:   secrectKey,
 			expectedStatus: http.StatusOK,
 			expectedBody:   "next called",
 			isJSON:         false,
 		},
+		{
+			name:           "Success - Previous Key Before Expiry",
+			path:           "/write",
+			apiKeyHeader:   "old-secret-key",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			deprecation:    deprecated,
+			sunset:         sunset.Format(http.TimeFormat),
+		},
+		{
+			name:           "Success - Previous Key Without Expiry",
+			path:           "/write",
+			apiKeyHeader:   "oldest-secret-key",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			deprecation:    deprecated,
+		},
+		{
+			name:           "Success - Previous Key Containing @ With Expiry",
+			path:           "/write",
+			apiKeyHeader:   "p@ss@word",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			deprecation:    deprecated,
+			sunset:         sunset.Format(http.TimeFormat),
+		},
+		{
+			name:           "Success - Previous Key Containing @ Without Expiry",
+			path:           "/write",
+			apiKeyHeader:   "user@example",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			deprecation:    deprecated,
+		},
+		{
+			name:           "Failure - Previous Key After Expiry",
+			path:           "/write",
+			apiKeyHeader:   "retired-secret-key",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"Unauthorized"}`,
+			isJSON:         true,
+		},
 		{
 			name:           "Failure - No Key",
+			path:           "/read",
 			apiKeyHeader:   "incorrect key",
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   `{"error":"Unauthorized"}`,
@@ -37,11 +171,64 @@ func TestAuthMiddleware(t *testing.T) {
 		},
 		{
 			name:           "Failure - Without Key",
+			path:           "/read",
 			apiKeyHeader:   "",
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   `{"error":"Unauthorized"}`,
 			isJSON:         true,
 		},
+		{
+			name:           "Success - Issued Key With Scope",
+			path:           "/read",
+			apiKeyHeader:   readKey,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			sunset:         future.UTC().Format(http.TimeFormat),
+		},
+		{
+			name:           "Success - Rotated Key",
+			path:           "/read",
+			apiKeyHeader:   rotatedKey,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+			deprecation:    "^@" + strconv.FormatInt(past.Unix(), 10) + "$",
+			sunset:         future.UTC().Format(http.TimeFormat),
+		},
+		{
+			name:           "Success - Issued Key With Write Scope",
+			path:           "/write",
+			apiKeyHeader:   writeKey,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "next called",
+			isJSON:         false,
+		},
+		{
+			name:           "Failure - Missing Scope",
+			path:           "/write",
+			apiKeyHeader:   readKey,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"Missing scope products:write"}`,
+			isJSON:         true,
+			sunset:         future.UTC().Format(http.TimeFormat),
+		},
+		{
+			name:           "Failure - Expired Key",
+			path:           "/write",
+			apiKeyHeader:   expiredKey,
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"Unauthorized"}`,
+			isJSON:         true,
+		},
+		{
+			name:           "Failure - Revoked Key",
+			path:           "/write",
+			apiKeyHeader:   revokedKey,
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"Unauthorized"}`,
+			isJSON:         true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -50,11 +237,13 @@ func TestAuthMiddleware(t *testing.T) {
 
 			app.Use(AuthMiddleware())
 
-			app.Get("/test", func(c *fiber.Ctx) error {
+			next := func(c *fiber.Ctx) error {
 				return c.SendString("next called")
-			})
+			}
+			app.Get("/read", RequireScope(auth.ScopeProductsRead), next)
+			app.Get("/write", RequireScope(auth.ScopeProductsWrite), next)
 
-			req := httptest.NewRequest("GET", "/test", nil)
+			req := httptest.NewRequest("GET", tc.path, nil)
 			req.Header.Set("X-API-Key", tc.apiKeyHeader)
 
 			resp, err := app.Test(req)
@@ -66,6 +255,12 @@ func TestAuthMiddleware(t *testing.T) {
 			}()
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode, "The statusCode isn't expected")
+			if tc.deprecation == "" {
+				assert.Empty(t, resp.Header.Get("Deprecation"), "The Deprecation header isn't expected")
+			} else {
+				assert.Regexp(t, tc.deprecation, resp.Header.Get("Deprecation"), "The Deprecation header isn't expected")
+			}
+			assert.Equal(t, tc.sunset, resp.Header.Get("Sunset"), "The Sunset header isn't expected")
 
 			body, err := io.ReadAll(resp.Body)
 			assert.NoError(t, err, "Reading the response body should not fail")
@@ -78,3 +273,537 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestPreviousKeysDeprecation(t *testing.T) {
+	t.Setenv("API_SECRET_KEY", "test-secret-key")
+	t.Setenv("API_PREVIOUS_KEYS", "old-secret-key")
+	authFailures = newFailureTracker()
+
+	deprecation := func() string {
+		app := fiber.New()
+		app.Use(AuthMiddleware())
+		app.Get("/", func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusNoContent)
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "old-secret-key")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+		return resp.Header.Get("Deprecation")
+	}
+
+	// The date is configured, so it does not move when the service restarts.
+	t.Setenv("API_SECRET_KEY_ROTATED_AT", "2026-03-02T09:30:00Z")
+	assert.Equal(t, "@1772443800", deprecation())
+
+	t.Setenv("API_SECRET_KEY_ROTATED_AT", "")
+	assert.Equal(t, "@0", deprecation(), "previous keys are deprecated since an unknown time")
+}
+
+func TestAuthLockout(t *testing.T) {
+	t.Setenv("API_SECRET_KEY", "test-secret-key")
+	t.Setenv("AUTH_MAX_FAILURES", "3")
+	t.Setenv("AUTH_LOCKOUT_DURATION", "1m")
+	authFailures = newFailureTracker()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.APIKey{}); err != nil {
+		t.Fatalf("failed to auto migrate API keys: %v", err)
+	}
+	database.DB = db
+
+	// app.Test connections come from 0.0.0.0, trusted as the proxy.
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          []string{"0.0.0.0"},
+	})
+	app.Use(AuthMiddleware())
+	app.Get("/test", func(c *fiber.Ctx) error {
+		return c.SendString("next called")
+	})
+	send := func(key, ip string) *http.Response {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-API-Key", key)
+		req.Header.Set(fiber.HeaderXForwardedFor, ip)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	failures, lockouts := authFailuresTotal.Value(), authLockoutsTotal.Value()
+
+	// Successful requests do not reset the count, and clients are told apart
+	// by the address the proxy appended, not the ones they sent.
+	assert.Equal(t, http.StatusUnauthorized, send("wrong", "203.0.113.7").StatusCode)
+	assert.Equal(t, http.StatusOK, send("test-secret-key", "203.0.113.7").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send("wrong", "192.0.2.1, 203.0.113.7").StatusCode)
+	assert.Equal(t, http.StatusOK, send("test-secret-key", "203.0.113.7").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send("wrong", "192.0.2.2,203.0.113.7").StatusCode)
+
+	resp := send("test-secret-key", "203.0.113.7")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "the source is locked out, even with a valid key")
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, send("test-secret-key", "198.51.100.4, 203.0.113.7").StatusCode, "a forged address does not escape the lockout")
+	assert.Equal(t, http.StatusOK, send("test-secret-key", "203.0.113.7, 198.51.100.4").StatusCode, "other sources are not affected")
+
+	assert.Equal(t, failures+3, authFailuresTotal.Value())
+	assert.Equal(t, lockouts+1, authLockoutsTotal.Value())
+	assert.Equal(t, 1, authFailures.lockedOut(time.Now()))
+
+	// The proxy header of peers that are not trusted proxies is ignored.
+	untrusted := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor, EnableTrustedProxyCheck: true})
+	untrusted.Get("/ip", func(c *fiber.Ctx) error {
+		return c.SendString(clientIP(c))
+	})
+	req := httptest.NewRequest("GET", "/ip", nil)
+	req.Header.Set(fiber.HeaderXForwardedFor, "203.0.113.7")
+	resp, err = untrusted.Test(req)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", string(body))
+}
+
+func TestFailureTracker(t *testing.T) {
+	t.Setenv("AUTH_MAX_FAILURES", "3")
+	t.Setenv("AUTH_FAILURE_WINDOW", "1m")
+	t.Setenv("AUTH_LOCKOUT_DURATION", "10m")
+	tracker := newFailureTracker()
+	now := time.Now()
+
+	tracker.fail("203.0.113.7", now)
+	tracker.fail("203.0.113.7", now.Add(30*time.Second))
+	count, until := tracker.fail("203.0.113.7", now.Add(2*time.Minute))
+	assert.Equal(t, 1, count, "failures outside the window are forgotten")
+	assert.True(t, until.IsZero())
+
+	tracker.fail("203.0.113.7", now.Add(2*time.Minute))
+	count, until = tracker.fail("203.0.113.7", now.Add(2*time.Minute))
+	assert.Equal(t, 3, count)
+	assert.Equal(t, now.Add(12*time.Minute), until)
+
+	assert.Equal(t, until, tracker.lockedUntil("203.0.113.7", now.Add(5*time.Minute)))
+	assert.True(t, tracker.lockedUntil("203.0.113.7", now.Add(12*time.Minute)).IsZero(), "the lockout ends")
+	assert.True(t, tracker.lockedUntil("198.51.100.4", now).IsZero())
+
+	// A full tracker makes room by dropping clients that are not locked out,
+	// and stops tracking new clients when all of them are.
+	tracker = newFailureTracker()
+	tracker.maxClients = 2
+	tracker.fail("203.0.113.7", now)
+	tracker.fail("203.0.113.7", now)
+	tracker.fail("203.0.113.7", now)
+	tracker.fail("198.51.100.4", now)
+	count, _ = tracker.fail("192.0.2.1", now)
+	assert.Equal(t, 1, count)
+	assert.Len(t, tracker.clients, 2)
+	assert.False(t, tracker.lockedUntil("203.0.113.7", now).IsZero(), "lockouts are kept")
+
+	for range 2 {
+		tracker.fail("192.0.2.1", now)
+	}
+	count, _ = tracker.fail("192.0.2.2", now)
+	assert.Equal(t, 0, count)
+	assert.Len(t, tracker.clients, 2)
+}
+
+func TestSignedRequests(t *testing.T) {
+	t.Setenv("API_SECRET_KEY", "test-secret-key")
+	t.Setenv("API_PREVIOUS_KEYS", "old-secret-key")
+	t.Setenv("REQUEST_SIGNATURE_SKEW", "1m")
+	authFailures = newFailureTracker()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.APIKey{}, &models.RequestNonce{}); err != nil {
+		t.Fatalf("failed to auto migrate API keys: %v", err)
+	}
+	database.DB = db
+
+	writeKey := issueSigningKey(t, "backoffice", []string{auth.ScopeProductsWrite}, "backoffice-signing-secret")
+	readKey := issueSigningKey(t, "storefront", []string{auth.ScopeProductsRead}, "storefront-signing-secret")
+	legacyKey := issueSigningKey(t, "legacy", []string{auth.ScopeProductsWrite}, "")
+
+	app := fiber.New()
+	app.Use(AuthMiddleware())
+	app.Post("/products", RequireScope(auth.ScopeProductsWrite), func(c *fiber.Ctx) error {
+		return c.SendString(Principal(c).Credential)
+	})
+
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := `{"name": "Doce de cupuaçu"}`
+
+	testCases := []struct {
+		name           string
+		keyID          string
+		secret         string
+		target         string
+		timestamp      string
+		nonce          string
+		signedBody     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Success - Signed With The Secret",
+			secret:         "test-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0001",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "env:API_SECRET_KEY (signed)",
+		},
+		{
+			name:           "Success - Signed With A Previous Key",
+			secret:         "old-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0002",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "env:API_PREVIOUS_KEYS#1 (signed)",
+		},
+		{
+			name:           "Success - Signed With An Issued Key",
+			keyID:          writeKey.ID.String(),
+			secret:         "backoffice-signing-secret",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0007",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "api_key:" + writeKey.ID.String() + " (signed)",
+		},
+		{
+			name:           "Failure - Issued Key Without The Scope",
+			keyID:          readKey.ID.String(),
+			secret:         "storefront-signing-secret",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0008",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"Missing scope products:write"}`,
+		},
+		{
+			name:           "Failure - Signed With Another Key's Secret",
+			keyID:          readKey.ID.String(),
+			secret:         "backoffice-signing-secret",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0009",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Secret With A Key ID",
+			keyID:          writeKey.ID.String(),
+			secret:         "test-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0010",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Issued Key Without Signing Secret",
+			keyID:          legacyKey.ID.String(),
+			secret:         "",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0011",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Replayed Nonce",
+			secret:         "test-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0001",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"request nonce was already used"}`,
+		},
+		{
+			name:           "Failure - Wrong Secret",
+			secret:         "guessed-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0003",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Tampered Body",
+			secret:         "test-secret-key",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0004",
+			signedBody:     `{"name": "Doce de buriti"}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Other Path",
+			secret:         "test-secret-key",
+			target:         "/products?dry_run=true",
+			timestamp:      timestamp,
+			nonce:          "2f1c4bfa-nonce-0005",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid request signature"}`,
+		},
+		{
+			name:           "Failure - Stale Timestamp",
+			secret:         "test-secret-key",
+			timestamp:      strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10),
+			nonce:          "2f1c4bfa-nonce-0006",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"request timestamp is outside the accepted clock skew"}`,
+		},
+		{
+			name:           "Failure - Short Nonce",
+			secret:         "test-secret-key",
+			timestamp:      timestamp,
+			nonce:          "1",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"request nonce must be between 16 and 128 characters"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signedBody := body
+			if tc.signedBody != "" {
+				signedBody = tc.signedBody
+			}
+			target := "/products"
+			if tc.target != "" {
+				target = tc.target
+			}
+
+			req := httptest.NewRequest("POST", target, strings.NewReader(body))
+			if tc.keyID != "" {
+				req.Header.Set(KeyIDHeader, tc.keyID)
+			}
+			req.Header.Set(TimestampHeader, tc.timestamp)
+			req.Header.Set(NonceHeader, tc.nonce)
+			req.Header.Set(SignatureHeader, auth.Sign(tc.secret, "POST", "/products", tc.timestamp, tc.nonce, []byte(signedBody)))
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			respBody, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedBody, string(respBody))
+		})
+	}
+
+	t.Run("Failure - Unsigned Secret When Signatures Are Required", func(t *testing.T) {
+		t.Setenv("AUTH_REQUIRE_SIGNATURE", "true")
+		req := httptest.NewRequest("POST", "/products", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "test-secret-key")
+
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+// signToken builds a JWT with claims, signed with key: an HMAC secret, an RSA
+// or a P-256 private key.
+func signToken(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch key := key.(type) {
+	case string:
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.NoError(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestBearerTokens(t *testing.T) {
+	t.Setenv("API_SECRET_KEY", "test-secret-key")
+	t.Setenv("JWT_HS256_SECRETS", "old-jwt-secret, jwt-secret")
+	t.Setenv("JWT_ISSUER", "sabordarondonia-api")
+	t.Setenv("JWT_AUDIENCE", "products")
+	authFailures = newFailureTracker()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	document, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-2026", "use": "sig", "alg": "RS256", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec-2026", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+	}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(jwksFile, document, 0o600))
+	t.Setenv("JWT_JWKS_FILE", jwksFile)
+
+	app := fiber.New()
+	app.Use(AuthMiddleware())
+	next := func(c *fiber.Ctx) error {
+		return c.SendString(Principal(c).Credential)
+	}
+	app.Get("/read", RequireScope(auth.ScopeProductsRead), next)
+	app.Get("/write", RequireScope(auth.ScopeProductsWrite), next)
+
+	now := time.Now()
+	claims := func(changes map[string]any) map[string]any {
+		values := map[string]any{
+			"iss":   "sabordarondonia-api",
+			"sub":   "storefront",
+			"aud":   []string{"products", "orders"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "products:read products:write",
+		}
+		for claim, value := range changes {
+			if value == nil {
+				delete(values, claim)
+			} else {
+				values[claim] = value
+			}
+		}
+		return values
+	}
+
+	testCases := []struct {
+		name           string
+		path           string
+		token          string
+		allowedScopes  string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Success - HS256",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(nil)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "jwt:hs256",
+		},
+		{
+			name:           "Success - Previous HS256 Secret",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "old-jwt-secret", claims(nil)),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "jwt:hs256",
+		},
+		{
+			name:           "Success - RS256 From JWKS",
+			path:           "/read",
+			token:          signToken(t, "RS256", "rsa-2026", rsaKey, claims(map[string]any{"aud": "products"})),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "jwt:rsa-2026",
+		},
+		{
+			name:           "Success - ES256 From JWKS",
+			path:           "/read",
+			token:          signToken(t, "ES256", "ec-2026", ecKey, claims(map[string]any{"scope": nil, "scp": []string{"products:read"}})),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "jwt:ec-2026",
+		},
+		{
+			name:           "Failure - Write Scope Is Not Allowed For Tokens",
+			path:           "/write",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(nil)),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   `{"error":"Missing scope products:write"}`,
+		},
+		{
+			name:           "Success - Write Scope Allowed By Configuration",
+			path:           "/write",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(nil)),
+			allowedScopes:  "products:read products:write",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "jwt:hs256",
+		},
+		{
+			name:           "Failure - Wrong Secret",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "guessed-secret", claims(nil)),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid bearer token"}`,
+		},
+		{
+			name:           "Failure - Unknown Key ID",
+			path:           "/read",
+			token:          signToken(t, "RS256", "rsa-2025", rsaKey, claims(nil)),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid bearer token"}`,
+		},
+		{
+			name:           "Failure - Unsigned Token",
+			path:           "/read",
+			token:          strings.TrimSuffix(signToken(t, "none", "", "", claims(nil)), "."),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"invalid bearer token"}`,
+		},
+		{
+			name:           "Failure - Expired",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"bearer token expired"}`,
+		},
+		{
+			name:           "Failure - Without Expiry",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(map[string]any{"exp": nil})),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"bearer token not accepted"}`,
+		},
+		{
+			name:           "Failure - Other Issuer",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(map[string]any{"iss": "someone-else"})),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"bearer token not accepted"}`,
+		},
+		{
+			name:           "Failure - Other Audience",
+			path:           "/read",
+			token:          signToken(t, "HS256", "", "jwt-secret", claims(map[string]any{"aud": "orders"})),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"bearer token not accepted"}`,
+		},
+	}
+
+	failures := authFailuresTotal.Value()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.allowedScopes != "" {
+				t.Setenv("JWT_ALLOWED_SCOPES", tc.allowedScopes)
+			}
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+
+	// Only the tokens whose signature failed count toward the lockout, not
+	// the validly signed ones that are expired or not accepted.
+	assert.Equal(t, failures+3, authFailuresTotal.Value())
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit rejects requests whose body is larger than limit bytes with 413
+// Request Entity Too Large.
+//
+// The app must stream request bodies (fiber.Config StreamRequestBody) over
+// its BodyLimit, which stays at the default: bodies up to it are read as
+// usual, larger ones are only accepted by routes allowing them with this
+// middleware. Registered with app.Use, it refuses every streamed body, so
+// routes registered after it keep the default limit.
+func BodyLimit(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request := c.Request()
+		if request.IsBodyStream() {
+			// The server does not drain what is left of a streamed body, which
+			// would be read as the next request on a kept alive connection.
+			c.Context().SetConnectionClose()
+		}
+		length := request.Header.ContentLength()
+		if request.IsBodyStream() && length < 0 {
+			return c.Status(fiber.StatusLengthRequired).JSON(fiber.Map{"error": "Content-Length is required for large request bodies"})
+		}
+		if length > limit || (!request.IsBodyStream() && len(request.Body()) > limit) {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "Request body too large"})
+		}
+		return c.Next()
+	}
+}
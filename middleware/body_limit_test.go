@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"bytes"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	const uploadLimit = 2 * fiber.DefaultBodyLimit
+
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+	ok := func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	app.Post("/upload", BodyLimit(uploadLimit), ok)
+	app.Use(BodyLimit(fiber.DefaultBodyLimit))
+	app.Post("/json", ok)
+
+	tests := []struct {
+		description  string
+		route        string
+		size         int
+		chunked      bool
+		expectedCode int
+	}{
+		{"Small JSON Body", "/json", 1024, false, fiber.StatusNoContent},
+		{"JSON Body Over The Default Limit", "/json", fiber.DefaultBodyLimit + 1, false, fiber.StatusRequestEntityTooLarge},
+		{"Upload Over The Default Limit", "/upload", fiber.DefaultBodyLimit + 1, false, fiber.StatusNoContent},
+		{"Upload Over Its Limit", "/upload", uploadLimit + 1, false, fiber.StatusRequestEntityTooLarge},
+		{"Chunked Upload Over The Default Limit", "/upload", fiber.DefaultBodyLimit + 1, true, fiber.StatusLengthRequired},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.route, bytes.NewReader(make([]byte, test.size)))
+		if test.chunked {
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err, test.description)
+		assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+	}
+}
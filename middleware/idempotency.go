@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"products/config"
+	"products/database"
+	"products/models"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyTTL    = 24 * time.Hour
+	defaultIdempotencyLease  = 5 * time.Minute
+)
+
+// Idempotency makes the handlers after it safe to retry. The first response to
+// a request carrying an Idempotency-Key header is stored, keyed by the caller
+// and the idempotency key, and replayed for every retry with the same
+// method, path and body. Reusing a key for a different request is rejected.
+// Responses with a 5xx status are not stored, so those requests can be retried.
+// Retries get 409 while the first request is processed, for up to
+// IDEMPOTENCY_LEASE: after that, the first request is assumed lost, as when
+// its process was killed, and a retry processes the request again. Keys expire
+// after IDEMPOTENCY_TTL.
+func Idempotency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotency-Key is too long"})
+		}
+
+		db := database.DB
+		scope := hashString(idempotencyScope(c))
+		requestHash := hashString(c.Method() + "\n" + c.Path() + "\n" + string(c.Body()))
+		now := time.Now()
+		cutoff := now.Add(-config.Duration("IDEMPOTENCY_TTL", defaultIdempotencyTTL))
+		lease := config.Duration("IDEMPOTENCY_LEASE", defaultIdempotencyLease)
+		lockedUntil := now.Add(lease)
+
+		record := models.IdempotencyRecord{APIKeyHash: scope, IdempotencyKey: key, RequestHash: requestHash, LockedUntil: &lockedUntil}
+		for attempt := 0; ; attempt++ {
+			result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+			if result.Error != nil {
+				log.Printf("Error storing idempotency key: %s", result.Error)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not process request"})
+			}
+			if result.RowsAffected == 1 {
+				break
+			}
+
+			var existing models.IdempotencyRecord
+			err := db.Where("api_key_hash = ? AND idempotency_key = ?", scope, key).First(&existing).Error
+			if err != nil {
+				log.Printf("Error loading idempotency key: %s", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not process request"})
+			}
+			if existing.CreatedAt.Before(cutoff) && attempt == 0 {
+				db.Delete(&existing)
+				continue
+			}
+			if existing.StatusCode == 0 && existing.RequestHash == requestHash && leaseExpired(existing, lease, now) {
+				taken, err := takeOverIdempotencyRecord(db, existing, lockedUntil)
+				if err != nil {
+					log.Printf("Error taking over idempotency key: %s", err)
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not process request"})
+				}
+				if taken {
+					record = existing
+					break
+				}
+			}
+			return replayIdempotentResponse(c, existing, requestHash)
+		}
+
+		if err := db.Where("api_key_hash = ? AND created_at < ?", scope, cutoff).Delete(&models.IdempotencyRecord{}).Error; err != nil {
+			log.Printf("Error purging expired idempotency keys: %s", err)
+		}
+
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil || status >= fiber.StatusInternalServerError {
+			if err := db.Delete(&record).Error; err != nil {
+				log.Printf("Error releasing idempotency key: %s", err)
+			}
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"status_code":   status,
+			"content_type":  string(c.Response().Header.ContentType()),
+			"response_body": append([]byte(nil), c.Response().Body()...),
+		}
+		if err := db.Model(&record).Updates(updates).Error; err != nil {
+			log.Printf("Error storing idempotent response: %s", err)
+		}
+		return nil
+	}
+}
+
+// leaseExpired reports whether the request processing record has not finished
+// within its lease. Records saved before leases existed are given one from
+// their creation.
+func leaseExpired(record models.IdempotencyRecord, lease time.Duration, now time.Time) bool {
+	if record.LockedUntil != nil {
+		return !now.Before(*record.LockedUntil)
+	}
+	return !now.Before(record.CreatedAt.Add(lease))
+}
+
+// takeOverIdempotencyRecord renews the lease of a record whose request was
+// lost, unless another retry renewed it first.
+func takeOverIdempotencyRecord(db *gorm.DB, record models.IdempotencyRecord, lockedUntil time.Time) (bool, error) {
+	query := db.Model(&models.IdempotencyRecord{}).Where("id = ? AND status_code = 0", record.ID)
+	if record.LockedUntil != nil {
+		query = query.Where("locked_until = ?", *record.LockedUntil)
+	} else {
+		query = query.Where("locked_until IS NULL")
+	}
+	result := query.Update("locked_until", lockedUntil)
+	return result.RowsAffected == 1, result.Error
+}
+
+func replayIdempotentResponse(c *fiber.Ctx, record models.IdempotencyRecord, requestHash string) error {
+	if record.RequestHash != requestHash {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Idempotency-Key was already used for a different request"})
+	}
+	if record.StatusCode == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A request with this Idempotency-Key is still being processed"})
+	}
+
+	c.Set(IdempotentReplayedHeader, "true")
+	if record.ContentType != "" {
+		c.Set(fiber.HeaderContentType, record.ContentType)
+	}
+	return c.Status(record.StatusCode).Send(record.ResponseBody)
+}
+
+func hashString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyScope identifies the caller idempotency keys belong to: the
+// authenticated principal, or the API key sent when there is none.
+func idempotencyScope(c *fiber.Ctx) string {
+	if principal := Principal(c); principal != nil {
+		return principal.Subject
+	}
+	return c.Get("X-API-Key")
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net/http/httptest"
+	"products/database"
+	"products/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func setupIdempotencyTest(t *testing.T) (*fiber.App, *int) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.IdempotencyRecord{}); err != nil {
+		t.Fatalf("failed to auto migrate idempotency records: %v", err)
+	}
+	db.Exec("DELETE FROM idempotency_records")
+	database.DB = db
+
+	calls := 0
+	app := fiber.New()
+	app.Post("/items/:id", Idempotency(), func(c *fiber.Ctx) error {
+		calls++
+		if c.Params("id") == "broken" {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "boom"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"call": calls})
+	})
+	return app, &calls
+}
+
+func postItem(t *testing.T, app *fiber.App, path, key, apiKey, body string) (int, string, string) {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close response body: %v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(respBody), resp.Header.Get(IdempotentReplayedHeader)
+}
+
+func TestIdempotencyReplaysFirstResponse(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+
+	status, body, replayed := postItem(t, app, "/items/1", "key-1", "client-a", `{"quantity_change":-1}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.JSONEq(t, `{"call":1}`, body)
+	assert.Empty(t, replayed)
+
+	status, body, replayed = postItem(t, app, "/items/1", "key-1", "client-a", `{"quantity_change":-1}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.JSONEq(t, `{"call":1}`, body)
+	assert.Equal(t, "true", replayed)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotencyRejectsDifferentPayload(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+
+	postItem(t, app, "/items/1", "key-1", "client-a", `{"quantity_change":-1}`)
+
+	status, body, _ := postItem(t, app, "/items/1", "key-1", "client-a", `{"quantity_change":-5}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	assert.JSONEq(t, `{"error":"Idempotency-Key was already used for a different request"}`, body)
+
+	status, _, _ = postItem(t, app, "/items/2", "key-1", "client-a", `{"quantity_change":-1}`)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, status)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotencyKeysAreScopedByAPIKey(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+
+	postItem(t, app, "/items/1", "key-1", "client-a", `{}`)
+	status, body, replayed := postItem(t, app, "/items/1", "key-1", "client-b", `{}`)
+
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.JSONEq(t, `{"call":2}`, body)
+	assert.Empty(t, replayed)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyDoesNotStoreServerErrors(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+
+	for i := 1; i <= 2; i++ {
+		status, _, replayed := postItem(t, app, "/items/broken", "key-1", "client-a", `{}`)
+		assert.Equal(t, fiber.StatusInternalServerError, status)
+		assert.Empty(t, replayed)
+		assert.Equal(t, i, *calls, "attempt "+strconv.Itoa(i)+" should reach the handler")
+	}
+}
+
+func TestIdempotencyWithoutKey(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+
+	postItem(t, app, "/items/1", "", "client-a", `{}`)
+	postItem(t, app, "/items/1", "", "client-a", `{}`)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyTakesOverLostRequests(t *testing.T) {
+	app, calls := setupIdempotencyTest(t)
+	requestHash := hashString("POST\n/items/1\n{}")
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+
+	// A request still being processed within its lease is not run again.
+	database.DB.Create(&models.IdempotencyRecord{
+		APIKeyHash:     hashString("client-a"),
+		IdempotencyKey: "in-flight",
+		RequestHash:    requestHash,
+		LockedUntil:    &future,
+	})
+	status, _, _ := postItem(t, app, "/items/1", "in-flight", "client-a", `{}`)
+	assert.Equal(t, fiber.StatusConflict, status)
+	assert.Equal(t, 0, *calls)
+
+	// One whose lease expired, as when its process was killed, is run by the
+	// retry, and its response is then replayed.
+	database.DB.Create(&models.IdempotencyRecord{
+		APIKeyHash:     hashString("client-a"),
+		IdempotencyKey: "lost",
+		RequestHash:    requestHash,
+		LockedUntil:    &past,
+	})
+	status, body, replayed := postItem(t, app, "/items/1", "lost", "client-a", `{}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.JSONEq(t, `{"call":1}`, body)
+	assert.Empty(t, replayed)
+
+	status, body, replayed = postItem(t, app, "/items/1", "lost", "client-a", `{}`)
+	assert.Equal(t, fiber.StatusCreated, status)
+	assert.JSONEq(t, `{"call":1}`, body)
+	assert.Equal(t, "true", replayed)
+	assert.Equal(t, 1, *calls)
+}
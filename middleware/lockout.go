@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"expvar"
+	"products/config"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuthMaxFailures   = 10
+	defaultAuthFailureWindow = 5 * time.Minute
+	defaultAuthLockout       = 15 * time.Minute
+	// maxTrackedClients bounds the memory used by the failure counts.
+	maxTrackedClients = 100000
+)
+
+var (
+	authFailuresTotal = expvar.NewInt("auth_failures_total")
+	authLockoutsTotal = expvar.NewInt("auth_lockouts_total")
+	authBlockedTotal  = expvar.NewInt("auth_blocked_requests_total")
+
+	authFailures = newFailureTracker()
+)
+
+func init() {
+	expvar.Publish("auth_locked_out_clients", expvar.Func(func() any {
+		return authFailures.lockedOut(time.Now())
+	}))
+}
+
+// failureTracker counts the invalid credentials sent by each client and locks
+// out clients that send AUTH_MAX_FAILURES of them within AUTH_FAILURE_WINDOW
+// for AUTH_LOCKOUT_DURATION. Successful requests do not reset the count, which
+// only expires with the window, so valid requests cannot hide guesses. Counts
+// are kept in memory, per instance, for at most maxClients clients.
+type failureTracker struct {
+	mu         sync.Mutex
+	clients    map[string]*clientFailures
+	maxClients int
+	lastSweep  time.Time
+}
+
+type clientFailures struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{clients: map[string]*clientFailures{}, maxClients: maxTrackedClients}
+}
+
+// lockedUntil returns when the lockout of client ends, or the zero time when
+// it is not locked out.
+func (t *failureTracker) lockedUntil(client string, now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failures, ok := t.clients[client]
+	if !ok || !now.Before(failures.lockedUntil) {
+		return time.Time{}
+	}
+	return failures.lockedUntil
+}
+
+// fail records an invalid credential from client, and returns when its
+// lockout ends if this failure locked it out.
+func (t *failureTracker) fail(client string, now time.Time) (int, time.Time) {
+	window := config.Duration("AUTH_FAILURE_WINDOW", defaultAuthFailureWindow)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now, window)
+
+	failures, ok := t.clients[client]
+	if !ok && len(t.clients) >= t.maxClients && !t.evict(now, window) {
+		// Every tracked client is locked out; new ones are not tracked
+		// until their lockouts end.
+		return 0, time.Time{}
+	}
+	if !ok || now.Sub(failures.windowStart) > window {
+		failures = &clientFailures{windowStart: now}
+		t.clients[client] = failures
+	}
+	failures.count++
+	if failures.count < int(config.Int64("AUTH_MAX_FAILURES", defaultAuthMaxFailures)) {
+		return failures.count, time.Time{}
+	}
+
+	count := failures.count
+	failures.count = 0
+	failures.windowStart = now
+	failures.lockedUntil = now.Add(config.Duration("AUTH_LOCKOUT_DURATION", defaultAuthLockout))
+	return count, failures.lockedUntil
+}
+
+func (t *failureTracker) lockedOut(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	locked := 0
+	for _, failures := range t.clients {
+		if now.Before(failures.lockedUntil) {
+			locked++
+		}
+	}
+	return locked
+}
+
+// sweep drops the clients whose failures and lockout are over, at most once
+// per window.
+func (t *failureTracker) sweep(now time.Time, window time.Duration) {
+	if now.Sub(t.lastSweep) < window {
+		return
+	}
+	t.lastSweep = now
+	t.dropExpired(now, window)
+}
+
+func (t *failureTracker) dropExpired(now time.Time, window time.Duration) {
+	for client, failures := range t.clients {
+		if now.Sub(failures.windowStart) > window && !now.Before(failures.lockedUntil) {
+			delete(t.clients, client)
+		}
+	}
+}
+
+// evict makes room for a new client in a full tracker: it drops the expired
+// clients, or else the failures of a client that is not locked out. It
+// reports false when every tracked client is locked out.
+func (t *failureTracker) evict(now time.Time, window time.Duration) bool {
+	t.dropExpired(now, window)
+	if len(t.clients) < t.maxClients {
+		return true
+	}
+	for client, failures := range t.clients {
+		if !now.Before(failures.lockedUntil) {
+			delete(t.clients, client)
+			return true
+		}
+	}
+	return false
+}
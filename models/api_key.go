@@ -0,0 +1,43 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// APIKey is a named credential of an API consumer. Only the SHA-256 hash of
+// the key is stored; the key itself is shown once, when it is issued.
+type APIKey struct {
+	ID   uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	Name string    `json:"name" gorm:"not null"`
+	// Prefix is the start of the key, to tell keys apart without revealing
+	// them.
+	Prefix  string `json:"prefix" gorm:"not null"`
+	KeyHash string `json:"-" gorm:"not null;uniqueIndex"`
+	// SigningSecret signs requests made with the key, identified by its ID,
+	// without sending it. Unlike the key, it is stored as is, since
+	// signatures can only be verified with it. Keys issued before requests
+	// could be signed have none.
+	SigningSecret string     `json:"-" gorm:"not null;default:''"`
+	Scopes        []string   `json:"scopes" gorm:"serializer:json"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+	// ReplacedByID is the key issued when this one was rotated. The key keeps
+	// working until its ExpiresAt.
+	ReplacedByID *uuid.UUID `json:"replaced_by_id,omitempty" gorm:"type:uuid"`
+	RotatedAt    *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the key can be used at now.
+func (key *APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+func (key *APIKey) BeforeCreate(tx *gorm.DB) (err error) {
+	key.ID = uuid.New()
+	return
+}
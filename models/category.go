@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Category struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;"`
+	Name      string     `json:"name" gorm:"not null;uniqueIndex:idx_categories_parent_name"`
+	ParentID  *uuid.UUID `json:"parent_id,omitempty" gorm:"type:uuid;uniqueIndex:idx_categories_parent_name"`
+	Children  []Category `json:"children,omitempty" gorm:"foreignKey:ParentID"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func (category *Category) BeforeCreate(tx *gorm.DB) (err error) {
+	category.ID = uuid.New()
+	return
+}
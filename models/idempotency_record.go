@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// IdempotencyRecord stores the first response given to a request sent with an
+// Idempotency-Key header, so retries of that request can be answered with it
+// instead of being applied again. StatusCode is zero while the first request
+// is still being processed, which it holds a lease on until LockedUntil.
+type IdempotencyRecord struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	APIKeyHash     string    `json:"-" gorm:"not null;uniqueIndex:idx_idempotency_records_scope"`
+	IdempotencyKey string    `json:"idempotency_key" gorm:"not null;uniqueIndex:idx_idempotency_records_scope"`
+	RequestHash    string    `json:"request_hash" gorm:"not null"`
+	StatusCode     int       `json:"status_code"`
+	// LockedUntil is when a retry may take over a request that is still
+	// being processed, such as one whose process was killed.
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	ContentType  string     `json:"content_type"`
+	ResponseBody []byte     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (record *IdempotencyRecord) BeforeCreate(tx *gorm.DB) (err error) {
+	record.ID = uuid.New()
+	return
+}
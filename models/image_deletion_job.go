@@ -0,0 +1,33 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	ImageDeletionPending = "pending"
+	ImageDeletionFailed  = "failed"
+)
+
+// ImageDeletionJob is a file to remove from an image storage backend. Jobs
+// are queued in the same transaction that stops referencing the file, and
+// retried with a growing delay until they succeed, when they are deleted, or
+// run out of attempts and are marked failed.
+type ImageDeletionJob struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	StorageBackend string    `json:"storage_backend" gorm:"not null"`
+	PublicID       string    `json:"public_id" gorm:"not null"`
+	Status         string    `json:"status" gorm:"not null;index:idx_image_deletion_jobs_due"`
+	Attempts       int       `json:"attempts" gorm:"not null;default:0"`
+	LastError      *string   `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time `json:"next_attempt_at" gorm:"index:idx_image_deletion_jobs_due"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (job *ImageDeletionJob) BeforeCreate(tx *gorm.DB) (err error) {
+	job.ID = uuid.New()
+	return
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ImageUploadTicket records a direct image upload allowed for a product. Only
+// the public IDs of issued tickets can be completed, and each only once: the
+// ticket is claimed while its upload is processed, and deleted when the upload
+// is added to the gallery.
+type ImageUploadTicket struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	ProductID      uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index"`
+	StorageBackend string    `json:"storage_backend" gorm:"not null"`
+	PublicID       string    `json:"public_id" gorm:"not null;uniqueIndex"`
+	ExpiresAt      time.Time `json:"expires_at" gorm:"index"`
+	// ClaimedUntil is set while a completion processes the upload. A claim
+	// left by a completion that never finished can be taken over once it is
+	// past.
+	ClaimedUntil *time.Time `json:"claimed_until,omitempty"`
+	// ReceivedAt is when the file was sent to an upload URL of the local
+	// store, which accepts a single file per ticket.
+	ReceivedAt *time.Time `json:"received_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (ticket *ImageUploadTicket) BeforeCreate(tx *gorm.DB) (err error) {
+	ticket.ID = uuid.New()
+	return
+}
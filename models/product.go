@@ -11,10 +11,19 @@ type Product struct {
 	Name        string    `json:"name" gorm:"unique"`
 	Description *string   `json:"description,omitempty"`
 	ImageURL    *string   `json:"image_url,omitempty"`
-	Price       int64     `json:"price"`
-	Stock       int64     `json:"stock" gorm:"default:0"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	// ImageSizes holds the URLs of the renditions of the primary image by
+	// size name, and of the original image as "original".
+	ImageSizes map[string]string `json:"image_sizes,omitempty" gorm:"serializer:json"`
+	Price      int64             `json:"price"`
+	Stock      int64             `json:"stock" gorm:"default:0"`
+	Reserved   int64             `json:"reserved" gorm:"default:0"`
+	Categories []Category        `json:"categories,omitempty" gorm:"many2many:product_categories;"`
+	Variants   []Variant         `json:"variants,omitempty" gorm:"constraint:OnDelete:CASCADE;"`
+	// Images is the gallery of the product. ImageURL always holds the URL of
+	// its primary image.
+	Images    []ProductImage `json:"images,omitempty" gorm:"constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
 }
 
 func (product *Product) BeforeCreate(tx *gorm.DB) (err error) {
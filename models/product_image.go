@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ProductImage is one image of a product gallery. Images are shown in
+// position order; the primary image is also exposed as the product image_url.
+type ProductImage struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	ProductID uuid.UUID `json:"product_id" gorm:"type:uuid;not null;index"`
+	URL       string    `json:"url" gorm:"not null"`
+	PublicID  string    `json:"public_id" gorm:"not null"`
+	// StorageBackend is the storage the image and its renditions were
+	// uploaded to, such as "cloudinary" or "local".
+	StorageBackend string  `json:"storage_backend" gorm:"not null;default:''"`
+	Position       int     `json:"position" gorm:"not null;default:0"`
+	AltText        *string `json:"alt_text,omitempty"`
+	IsPrimary      bool    `json:"is_primary" gorm:"not null;default:false"`
+	// Renditions are the resized copies of the image by size name.
+	Renditions map[string]Rendition `json:"renditions,omitempty" gorm:"serializer:json"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+// Rendition is a resized copy of a product image. Sizes the image is already
+// smaller than point to the original, without a public ID of their own.
+type Rendition struct {
+	URL      string `json:"url"`
+	PublicID string `json:"public_id,omitempty"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+func (image *ProductImage) BeforeCreate(tx *gorm.DB) (err error) {
+	image.ID = uuid.New()
+	return
+}
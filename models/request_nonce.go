@@ -0,0 +1,22 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RequestNonce records a nonce used by a signed request, so the request cannot
+// be replayed. Nonces are kept until the timestamp they were signed with falls
+// outside the accepted clock skew.
+type RequestNonce struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;"`
+	NonceHash string    `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (nonce *RequestNonce) BeforeCreate(tx *gorm.DB) (err error) {
+	nonce.ID = uuid.New()
+	return
+}
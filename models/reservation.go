@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	ReservationPending   = "pending"
+	ReservationConfirmed = "confirmed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds stock for a checkout until it is confirmed, released or
+// expires. While pending, its quantities are counted in the Reserved column of
+// the products and variants it targets.
+type Reservation struct {
+	ID        uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;"`
+	Status    string            `json:"status" gorm:"not null;index"`
+	ExpiresAt time.Time         `json:"expires_at" gorm:"index"`
+	Lines     []ReservationLine `json:"lines" gorm:"constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type ReservationLine struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;"`
+	ReservationID uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	ProductID     uuid.UUID  `json:"product_id" gorm:"type:uuid;not null"`
+	VariantID     *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid"`
+	Quantity      int64      `json:"quantity"`
+}
+
+func (reservation *Reservation) BeforeCreate(tx *gorm.DB) (err error) {
+	reservation.ID = uuid.New()
+	return
+}
+
+func (line *ReservationLine) BeforeCreate(tx *gorm.DB) (err error) {
+	line.ID = uuid.New()
+	return
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	MovementSale       = "sale"
+	MovementRestock    = "restock"
+	MovementAdjustment = "adjustment"
+	MovementReturn     = "return"
+)
+
+var errStockMovementAppendOnly = errors.New("stock movements are append-only")
+
+// StockMovement is an entry of the inventory ledger: every change to the stock
+// of a product or variant is recorded with the resulting balance and why it
+// happened. Movements are never updated or deleted, and are kept after the
+// product is removed.
+type StockMovement struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;"`
+	ProductID   uuid.UUID  `json:"product_id" gorm:"type:uuid;not null;index"`
+	VariantID   *uuid.UUID `json:"variant_id,omitempty" gorm:"type:uuid;index"`
+	Delta       int64      `json:"delta"`
+	Balance     int64      `json:"balance"`
+	Reason      string     `json:"reason" gorm:"not null"`
+	ReferenceID *string    `json:"reference_id,omitempty" gorm:"index"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"index"`
+}
+
+// IsValidMovementReason reports whether reason is one of the known movement
+// reasons.
+func IsValidMovementReason(reason string) bool {
+	switch reason {
+	case MovementSale, MovementRestock, MovementAdjustment, MovementReturn:
+		return true
+	}
+	return false
+}
+
+func (movement *StockMovement) BeforeCreate(tx *gorm.DB) (err error) {
+	movement.ID = uuid.New()
+	return
+}
+
+func (movement *StockMovement) BeforeUpdate(tx *gorm.DB) (err error) {
+	return errStockMovementAppendOnly
+}
+
+func (movement *StockMovement) BeforeDelete(tx *gorm.DB) (err error) {
+	return errStockMovementAppendOnly
+}
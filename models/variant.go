@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Variant struct {
+	ID        uuid.UUID         `json:"id" gorm:"type:uuid;primary_key;"`
+	ProductID uuid.UUID         `json:"product_id" gorm:"type:uuid;not null;index"`
+	SKU       string            `json:"sku" gorm:"not null;unique"`
+	Options   map[string]string `json:"options,omitempty" gorm:"serializer:json"`
+	Price     int64             `json:"price"`
+	Stock     int64             `json:"stock" gorm:"default:0"`
+	Reserved  int64             `json:"reserved" gorm:"default:0"`
+	Barcode   *string           `json:"barcode,omitempty" gorm:"unique"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+func (variant *Variant) BeforeCreate(tx *gorm.DB) (err error) {
+	variant.ID = uuid.New()
+	return
+}
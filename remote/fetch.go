@@ -0,0 +1,155 @@
+// Package remote downloads files from URLs supplied by clients without letting
+// them reach private networks.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const maxRedirects = 3
+
+var (
+	ErrInvalidURL     = errors.New("URL must be an absolute http or https URL")
+	ErrBlockedAddress = errors.New("URL resolves to a private or reserved address")
+	ErrTooLarge       = errors.New("remote file is too large")
+	ErrFetchFailed    = errors.New("could not fetch the remote file")
+)
+
+// blockedPrefixes are the ranges that are not routable on the public
+// internet and IsPublic does not already cover through netip.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// IsPublic reports whether addr is a public unicast address: loopback,
+// private, link-local, multicast and reserved ranges are not.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetcher downloads files over HTTP. Addresses are checked when connecting,
+// after DNS resolution and on every redirect, so a host name cannot be used
+// to reach an address Allow refuses.
+type Fetcher struct {
+	Timeout  time.Duration
+	MaxBytes int64
+	// Allow decides which addresses may be connected to. It defaults to
+	// IsPublic.
+	Allow func(netip.Addr) bool
+}
+
+// Fetch downloads rawURL, failing with ErrTooLarge when the body is larger
+// than MaxBytes.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || !allowedScheme(target) || target.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBlockedAddress):
+			return nil, ErrBlockedAddress
+		case errors.Is(err, ErrInvalidURL):
+			return nil, ErrInvalidURL
+		}
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s responded %s", ErrFetchFailed, target.Host, resp.Status)
+	}
+	if resp.ContentLength > f.MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	if int64(len(data)) > f.MaxBytes {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+func (f *Fetcher) client() *http.Client {
+	allow := f.Allow
+	if allow == nil {
+		allow = IsPublic
+	}
+
+	dialer := &net.Dialer{
+		Timeout: f.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addrPort.Addr()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			// Proxies would connect on our behalf, bypassing the check.
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("%w: too many redirects", ErrFetchFailed)
+			}
+			if !allowedScheme(req.URL) {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+}
+
+func allowedScheme(target *url.URL) bool {
+	return target.Scheme == "http" || target.Scheme == "https"
+}
@@ -0,0 +1,91 @@
+package remote
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	testCases := []struct {
+		addr     string
+		expected bool
+	}{
+		{addr: "93.184.216.34", expected: true},
+		{addr: "2606:2800:220:1:248:1893:25c8:1946", expected: true},
+		{addr: "127.0.0.1", expected: false},
+		{addr: "10.1.2.3", expected: false},
+		{addr: "172.16.0.1", expected: false},
+		{addr: "192.168.0.10", expected: false},
+		{addr: "169.254.169.254", expected: false},
+		{addr: "100.64.0.1", expected: false},
+		{addr: "0.0.0.0", expected: false},
+		{addr: "224.0.0.1", expected: false},
+		{addr: "::1", expected: false},
+		{addr: "fd00::1", expected: false},
+		{addr: "fe80::1", expected: false},
+		{addr: "::ffff:127.0.0.1", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.addr, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsPublic(netip.MustParseAddr(tc.addr)))
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Write([]byte("png"))
+		case "/large.png":
+			w.Write([]byte(strings.Repeat("x", 64)))
+		case "/redirect":
+			http.Redirect(w, r, "/image.png", http.StatusFound)
+		case "/scheme":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	loopback := func(addr netip.Addr) bool { return addr.IsLoopback() }
+	fetcher := &Fetcher{Timeout: time.Second, MaxBytes: 16, Allow: loopback}
+
+	data, err := fetcher.Fetch(context.Background(), server.URL+"/image.png")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), data)
+
+	data, err = fetcher.Fetch(context.Background(), server.URL+"/redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), data)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/large.png")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/missing.png")
+	assert.ErrorIs(t, err, ErrFetchFailed)
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/scheme")
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	for _, rawURL := range []string{"ftp://example.com/image.png", "/image.png", "not a url"} {
+		_, err = fetcher.Fetch(context.Background(), rawURL)
+		assert.ErrorIs(t, err, ErrInvalidURL, rawURL)
+	}
+
+	// The default policy refuses loopback addresses, including through a
+	// host name.
+	public := &Fetcher{Timeout: time.Second, MaxBytes: 16}
+	_, err = public.Fetch(context.Background(), server.URL+"/image.png")
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+	_, err = public.Fetch(context.Background(), strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/image.png")
+	assert.ErrorIs(t, err, ErrBlockedAddress)
+}
@@ -0,0 +1,80 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrNoSecret means neither SIGNING_SECRET nor API_SECRET_KEY is set:
+	// tokens signed with an empty key could be forged by anyone.
+	ErrNoSecret = errors.New("no signing secret configured: set SIGNING_SECRET")
+)
+
+// Encode serializes value as JSON and returns it as an opaque, URL-safe token
+// signed with the service secret.
+func Encode(value any) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := sign([]byte(encoded))
+	if err != nil {
+		return "", err
+	}
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Decode verifies the signature of a token produced by Encode and unmarshals
+// its payload into value.
+func Decode(token string, value any) error {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalidToken
+	}
+
+	actual, err := sign([]byte(encoded))
+	if err != nil {
+		return err
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func sign(data []byte) ([]byte, error) {
+	key := secret()
+	if len(key) == 0 {
+		return nil, ErrNoSecret
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// secret returns the key used to sign tokens. SIGNING_SECRET is preferred; the
+// API secret is used as a fallback so existing deployments keep working.
+func secret() []byte {
+	if key := os.Getenv("SIGNING_SECRET"); key != "" {
+		return []byte(key)
+	}
+	return []byte(os.Getenv("API_SECRET_KEY"))
+}
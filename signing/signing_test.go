@@ -0,0 +1,68 @@
+package signing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type payload struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestEncodeDecode(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "test-signing-secret")
+
+	token, err := Encode(payload{Name: "cursor", Count: 3})
+	assert.NoError(t, err)
+
+	var decoded payload
+	assert.NoError(t, Decode(token, &decoded))
+	assert.Equal(t, payload{Name: "cursor", Count: 3}, decoded)
+}
+
+func TestDecodeRejectsInvalidTokens(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "test-signing-secret")
+
+	token, err := Encode(payload{Name: "cursor"})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		token string
+		setup func(t *testing.T)
+	}{
+		{name: "Empty token", token: ""},
+		{name: "Missing signature", token: "eyJuYW1lIjoiY3Vyc29yIn0"},
+		{name: "Tampered signature", token: token + "A"},
+		{name: "Tampered payload", token: "eyJuYW1lIjoib3RoZXIifQ" + token[len("eyJuYW1lIjoiY3Vyc29yIiwiY291bnQiOjB9"):]},
+		{
+			name:  "Different secret",
+			token: token,
+			setup: func(t *testing.T) { t.Setenv("SIGNING_SECRET", "another-secret") },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup(t)
+			}
+			var decoded payload
+			assert.ErrorIs(t, Decode(tc.token, &decoded), ErrInvalidToken)
+		})
+	}
+}
+
+func TestRefusesEmptySecret(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "")
+	t.Setenv("API_SECRET_KEY", "")
+
+	_, err := Encode(payload{Name: "cursor"})
+	assert.ErrorIs(t, err, ErrNoSecret)
+
+	// A token signed with an empty key is not accepted either.
+	var decoded payload
+	token := "eyJuYW1lIjoiY3Vyc29yIn0.RMoFJMU5YDB9Nb5dyVNkaEB8X8bg7wgfRH7hLhgAlsw"
+	assert.ErrorIs(t, Decode(token, &decoded), ErrNoSecret)
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cloudinaryVersion matches the version segment of delivery URLs, such as
+// v1712345678.
+var cloudinaryVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// downloadClient reads direct uploads back from Cloudinary. Its timeout bounds
+// the whole download, body included, whatever the context of the caller.
+var downloadClient = &http.Client{Timeout: 2 * time.Minute}
+
+// CloudinaryStore keeps images in Cloudinary.
+type CloudinaryStore struct {
+	cld *cloudinary.Cloudinary
+}
+
+func NewCloudinaryStore(url string) (*CloudinaryStore, error) {
+	cld, err := cloudinary.NewFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudinaryStore{cld: cld}, nil
+}
+
+func (s *CloudinaryStore) Backend() string {
+	return BackendCloudinary
+}
+
+func (s *CloudinaryStore) Upload(ctx context.Context, file io.Reader, folder string) (*UploadResult, error) {
+	result, err := s.cld.Upload.Upload(ctx, file, uploader.UploadParams{Folder: folder})
+	if err != nil {
+		return nil, err
+	}
+	if result.Error.Message != "" {
+		return nil, errors.New(result.Error.Message)
+	}
+	return &UploadResult{PublicID: result.PublicID, URL: result.URL}, nil
+}
+
+func (s *CloudinaryStore) Delete(ctx context.Context, publicID string) error {
+	if publicID == "" {
+		return ErrInvalidPublicID
+	}
+	result, err := s.cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
+	if err != nil {
+		return err
+	}
+	if result.Error.Message != "" {
+		return errors.New(result.Error.Message)
+	}
+	return nil
+}
+
+func (s *CloudinaryStore) List(ctx context.Context, folder string) ([]Asset, error) {
+	var assets []Asset
+	params := admin.AssetsParams{
+		AssetType:    api.Image,
+		DeliveryType: "upload",
+		Prefix:       folder + "/",
+		MaxResults:   500,
+	}
+	for {
+		result, err := s.cld.Admin.Assets(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error.Message != "" {
+			return nil, errors.New(result.Error.Message)
+		}
+		for _, asset := range result.Assets {
+			assets = append(assets, Asset{PublicID: asset.PublicID, CreatedAt: asset.CreatedAt})
+		}
+		if result.NextCursor == "" {
+			return assets, nil
+		}
+		params.NextCursor = result.NextCursor
+	}
+}
+
+// SignUpload returns a signed Cloudinary upload request. Cloudinary accepts
+// signatures for an hour; the ticket expiry is enforced when the upload is
+// completed.
+func (s *CloudinaryStore) SignUpload(ctx context.Context, folder string, expiresAt time.Time) (*UploadTicket, error) {
+	cloud := s.cld.Config.Cloud
+	params := url.Values{
+		"public_id": {path.Join(folder, uuid.NewString())},
+		"timestamp": {strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	signature, err := api.SignParameters(params, cloud.APISecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadTicket{
+		Method: http.MethodPost,
+		URL:    fmt.Sprintf("%s/v1_1/%s/image/upload", s.cld.Config.API.UploadPrefix, cloud.CloudName),
+		Fields: map[string]string{
+			"api_key":   cloud.APIKey,
+			"public_id": params.Get("public_id"),
+			"timestamp": params.Get("timestamp"),
+			"signature": signature,
+		},
+		FileField: "file",
+		PublicID:  params.Get("public_id"),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (s *CloudinaryStore) Open(ctx context.Context, publicID string) (io.ReadCloser, error) {
+	asset, err := s.cld.Admin.Asset(ctx, admin.AssetParams{PublicID: publicID})
+	if err != nil {
+		return nil, err
+	}
+	if asset.Error.Message != "" {
+		if strings.Contains(strings.ToLower(asset.Error.Message), "not found") {
+			return nil, ErrUploadNotFound
+		}
+		return nil, errors.New(asset.Error.Message)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.SecureURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("downloading %s: %s", publicID, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *CloudinaryStore) URL(publicID string) (string, error) {
+	image, err := s.cld.Image(publicID)
+	if err != nil {
+		return "", err
+	}
+	return image.String()
+}
+
+func (s *CloudinaryStore) PublicID(url string) string {
+	return cloudinaryPublicID(url)
+}
+
+// cloudinaryPublicID extracts the public ID of an image from its Cloudinary
+// delivery URL, such as
+// https://res.cloudinary.com/<cloud>/image/upload/c_fill,w_300/v12/folder/name.jpg.
+// The public ID is the path after the version, or after the transformations
+// when there is no version, without the file extension.
+func cloudinaryPublicID(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if host := parsed.Hostname(); host != "cloudinary.com" && !strings.HasSuffix(host, ".cloudinary.com") {
+		return ""
+	}
+
+	// <cloud>/<resource type>/<delivery type>/...
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(segments) < 4 {
+		return ""
+	}
+	rest := segments[3:]
+
+	for i, segment := range rest {
+		if cloudinaryVersion.MatchString(segment) {
+			rest = rest[i+1:]
+			break
+		}
+	}
+	// Without a version, leading segments made of transformations such as
+	// c_fill,w_300 are skipped.
+	for len(rest) > 1 && isCloudinaryTransformation(rest[0]) {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return ""
+	}
+
+	publicID := strings.Join(rest, "/")
+	if dot := strings.LastIndex(publicID, "."); dot > strings.LastIndex(publicID, "/") {
+		publicID = publicID[:dot]
+	}
+	unescaped, err := url.PathUnescape(publicID)
+	if err != nil {
+		return ""
+	}
+	return unescaped
+}
+
+var cloudinaryTransformation = regexp.MustCompile(`^[a-z]{1,3}_[^/]+$`)
+
+func isCloudinaryTransformation(segment string) bool {
+	for _, part := range strings.Split(segment, ",") {
+		if !cloudinaryTransformation.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
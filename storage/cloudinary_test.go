@@ -0,0 +1,77 @@
+package storage
+
+import "testing"
+
+func TestCloudinaryPublicID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		inputURL string
+		expected string
+	}{
+		{
+			name:     "URL Válida",
+			inputURL: "http://res.cloudinary.com/cloud-name/image/upload/v12345/sabordarondonia/arquivo123.jpg",
+			expected: "sabordarondonia/arquivo123",
+		},
+		{
+			name:     "URL com HTTPS",
+			inputURL: "https://res.cloudinary.com/cloud-name/image/upload/v12345/sabordarondonia/outro_arquivo-abc.png",
+			expected: "sabordarondonia/outro_arquivo-abc",
+		},
+		{
+			name:     "URL de outra pasta",
+			inputURL: "http://res.cloudinary.com/cloud-name/image/upload/v12345/outra_pasta/arquivo123.jpg",
+			expected: "outra_pasta/arquivo123",
+		},
+		{
+			name:     "URL com transformações",
+			inputURL: "https://res.cloudinary.com/cloud-name/image/upload/c_fill,w_300,h_300/v12345/sabordarondonia/arquivo123.jpg",
+			expected: "sabordarondonia/arquivo123",
+		},
+		{
+			name:     "URL com transformações e sem versão",
+			inputURL: "https://res.cloudinary.com/cloud-name/image/upload/w_400/sabordarondonia/arquivo123.webp",
+			expected: "sabordarondonia/arquivo123",
+		},
+		{
+			name:     "URL com pontos no nome",
+			inputURL: "https://res.cloudinary.com/cloud-name/image/upload/v12345/sabordarondonia/bolo.de.pote.v2.png",
+			expected: "sabordarondonia/bolo.de.pote.v2",
+		},
+		{
+			name:     "URL sem extensão",
+			inputURL: "https://res.cloudinary.com/cloud-name/image/upload/v12345/sabordarondonia/arquivo123",
+			expected: "sabordarondonia/arquivo123",
+		},
+		{
+			name:     "URL de outro host",
+			inputURL: "https://example.com/cloud-name/image/upload/v12345/sabordarondonia/arquivo123.jpg",
+			expected: "",
+		},
+		{
+			name:     "URL de host que termina em cloudinary.com",
+			inputURL: "https://evilcloudinary.com/cloud-name/image/upload/v12345/sabordarondonia/arquivo123.jpg",
+			expected: "",
+		},
+		{
+			name:     "URL Vazia",
+			inputURL: "",
+			expected: "",
+		},
+		{
+			name:     "URL Mal Formada",
+			inputURL: "isto nao e uma url",
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := cloudinaryPublicID(tc.inputURL)
+
+			if actual != tc.expected {
+				t.Errorf("actual: %s, expected: %s", actual, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrDirectUploadUnsupported = errors.New("image storage backend does not support direct uploads")
+	ErrUploadNotFound          = errors.New("uploaded image not found")
+	ErrInvalidUploadURL        = errors.New("invalid or expired upload URL")
+)
+
+// UploadTicket describes how a client uploads one image straight to a
+// storage backend: a multipart form sent with Method to URL holding Fields
+// and the file in FileField.
+type UploadTicket struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	FileField string            `json:"file_field"`
+	PublicID  string            `json:"public_id"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// DirectUploader is implemented by stores that accept uploads from clients
+// without going through the application.
+type DirectUploader interface {
+	// SignUpload allows a single upload to a new public ID in folder until
+	// expiresAt.
+	SignUpload(ctx context.Context, folder string, expiresAt time.Time) (*UploadTicket, error)
+	// Open reads an image uploaded with a ticket, failing with
+	// ErrUploadNotFound when nothing was uploaded.
+	Open(ctx context.Context, publicID string) (io.ReadCloser, error)
+}
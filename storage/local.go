@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"products/signing"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLocalDir       = "./uploads"
+	defaultLocalBaseURL   = "/uploads"
+	defaultLocalUploadURL = "/api/uploads"
+)
+
+// extensions maps the sniffed content types of images to the extension their
+// files are stored with, so they are served with the right Content-Type.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// LocalStore keeps images on disk below Dir. They are expected to be served
+// by the application under BaseURL, and direct uploads to be received under
+// UploadURL. Direct uploads are kept below StagingDir until they are
+// completed, so files nobody has validated yet are never served.
+type LocalStore struct {
+	Dir        string
+	StagingDir string
+	BaseURL    string
+	UploadURL  string
+}
+
+// directUpload is the signed payload of a local upload URL.
+type directUpload struct {
+	PublicID  string    `json:"p"`
+	ExpiresAt time.Time `json:"e"`
+}
+
+// LocalDir is the directory local images are stored in, IMAGE_STORAGE_DIR.
+func LocalDir() string {
+	if dir := os.Getenv("IMAGE_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return defaultLocalDir
+}
+
+// LocalStagingDir is the directory direct uploads to the local store are
+// received in, IMAGE_UPLOAD_STAGING_DIR, by default next to dir. It must not
+// be served.
+func LocalStagingDir(dir string) string {
+	if stagingDir := os.Getenv("IMAGE_UPLOAD_STAGING_DIR"); stagingDir != "" {
+		return stagingDir
+	}
+	return filepath.Clean(dir) + "-staging"
+}
+
+// LocalBaseURL is the URL prefix local images are served under,
+// IMAGE_BASE_URL. It may be absolute, such as https://cdn.example.com/uploads.
+func LocalBaseURL() string {
+	if baseURL := os.Getenv("IMAGE_BASE_URL"); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	return defaultLocalBaseURL
+}
+
+// LocalUploadURL is the URL prefix direct uploads to the local store are sent
+// to, IMAGE_UPLOAD_URL. It must route to the direct upload handler.
+func LocalUploadURL() string {
+	if uploadURL := os.Getenv("IMAGE_UPLOAD_URL"); uploadURL != "" {
+		return strings.TrimSuffix(uploadURL, "/")
+	}
+	return defaultLocalUploadURL
+}
+
+func NewLocalStore(dir, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		Dir:        dir,
+		StagingDir: LocalStagingDir(dir),
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		UploadURL:  LocalUploadURL(),
+	}, nil
+}
+
+func (s *LocalStore) Backend() string {
+	return BackendLocal
+}
+
+func (s *LocalStore) Upload(ctx context.Context, file io.Reader, folder string) (*UploadResult, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	extension, ok := extensions[http.DetectContentType(head)]
+	if !ok {
+		extension = ".bin"
+	}
+	publicID := path.Join(folder, uuid.NewString()+extension)
+
+	target, err := s.path(publicID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so a failed upload never leaves a
+	// truncated image behind.
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return nil, err
+	}
+
+	url, _ := s.URL(publicID)
+	return &UploadResult{PublicID: publicID, URL: url}, nil
+}
+
+// Delete removes a stored image, or a direct upload that was not completed.
+func (s *LocalStore) Delete(ctx context.Context, publicID string) error {
+	for _, resolve := range []func(string) (string, error){s.path, s.stagedPath} {
+		target, err := resolve(publicID)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) List(ctx context.Context, folder string) ([]Asset, error) {
+	root, err := s.path(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []Asset
+	err = filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Temporary files of uploads in progress start with a dot.
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(s.Dir, file)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, Asset{PublicID: filepath.ToSlash(relative), CreatedAt: info.ModTime()})
+		return nil
+	})
+	return assets, err
+}
+
+// SignUpload returns an upload URL holding an HMAC-signed token with the
+// public ID and expiry, to be checked by VerifyUpload.
+func (s *LocalStore) SignUpload(ctx context.Context, folder string, expiresAt time.Time) (*UploadTicket, error) {
+	publicID := path.Join(folder, uuid.NewString())
+	token, err := signing.Encode(directUpload{PublicID: publicID, ExpiresAt: expiresAt})
+	if err != nil {
+		return nil, err
+	}
+	return &UploadTicket{
+		Method:    http.MethodPost,
+		URL:       s.UploadURL + "/" + token,
+		FileField: "file",
+		PublicID:  publicID,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// VerifyUpload checks a token of an upload URL of SignUpload and returns the
+// public ID to receive the file as.
+func (s *LocalStore) VerifyUpload(token string) (string, error) {
+	var upload directUpload
+	if err := signing.Decode(token, &upload); err != nil || !time.Now().Before(upload.ExpiresAt) {
+		return "", ErrInvalidUploadURL
+	}
+	if _, err := s.stagedPath(upload.PublicID); err != nil {
+		return "", ErrInvalidUploadURL
+	}
+	return upload.PublicID, nil
+}
+
+// Receive stores a file sent to an upload URL below StagingDir, where Open
+// reads it. It never overwrites an earlier upload; callers are expected to
+// only accept each URL once.
+func (s *LocalStore) Receive(ctx context.Context, publicID string, file io.Reader) error {
+	target, err := s.stagedPath(publicID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), target); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return ErrInvalidUploadURL
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Open(ctx context.Context, publicID string) (io.ReadCloser, error) {
+	target, err := s.stagedPath(publicID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStore) URL(publicID string) (string, error) {
+	if _, err := s.path(publicID); err != nil {
+		return "", err
+	}
+	return s.BaseURL + "/" + publicID, nil
+}
+
+func (s *LocalStore) PublicID(url string) string {
+	publicID, ok := strings.CutPrefix(url, s.BaseURL+"/")
+	if !ok {
+		return ""
+	}
+	if _, err := s.path(publicID); err != nil {
+		return ""
+	}
+	return publicID
+}
+
+// path resolves a public ID to its file, refusing IDs that would escape Dir.
+func (s *LocalStore) path(publicID string) (string, error) {
+	if publicID == "" || path.IsAbs(publicID) || path.Clean(publicID) != publicID || strings.HasPrefix(publicID, "..") {
+		return "", ErrInvalidPublicID
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(publicID)), nil
+}
+
+// stagedPath resolves the public ID of a direct upload to its file below
+// StagingDir.
+func (s *LocalStore) stagedPath(publicID string) (string, error) {
+	if _, err := s.path(publicID); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.StagingDir, filepath.FromSlash(publicID)), nil
+}
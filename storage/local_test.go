@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "https://cdn.example.com/uploads/")
+	assert.NoError(t, err)
+
+	jpeg := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 600)...)
+	result, err := store.Upload(context.Background(), bytes.NewReader(jpeg), ProductImagesFolder)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^sabordarondonia/[0-9a-f-]+\.jpg$`, result.PublicID)
+	assert.Equal(t, "https://cdn.example.com/uploads/"+result.PublicID, result.URL)
+
+	stored, err := os.ReadFile(filepath.Join(store.Dir, filepath.FromSlash(result.PublicID)))
+	assert.NoError(t, err)
+	assert.Equal(t, jpeg, stored)
+
+	assert.Equal(t, result.PublicID, store.PublicID(result.URL))
+	assert.Equal(t, "", store.PublicID("https://res.cloudinary.com/demo/image/upload/sample.jpg"))
+
+	assets, err := store.List(context.Background(), ProductImagesFolder)
+	assert.NoError(t, err)
+	if assert.Len(t, assets, 1) {
+		assert.Equal(t, result.PublicID, assets[0].PublicID)
+		assert.False(t, assets[0].CreatedAt.IsZero())
+	}
+	assets, err = store.List(context.Background(), "outra_pasta")
+	assert.NoError(t, err)
+	assert.Empty(t, assets, "listing a missing folder is not an error")
+
+	assert.NoError(t, store.Delete(context.Background(), result.PublicID))
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(result.PublicID)))
+	assert.NoError(t, store.Delete(context.Background(), result.PublicID), "deleting twice is not an error")
+}
+
+func TestLocalStoreRejectsEscapingPublicIDs(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "/uploads")
+	assert.NoError(t, err)
+
+	for _, publicID := range []string{"", "../secret.txt", "/etc/passwd", "sabordarondonia/../../secret.txt"} {
+		t.Run(publicID, func(t *testing.T) {
+			assert.ErrorIs(t, store.Delete(context.Background(), publicID), ErrInvalidPublicID)
+			_, err := store.URL(publicID)
+			assert.ErrorIs(t, err, ErrInvalidPublicID)
+		})
+	}
+	assert.Equal(t, "", store.PublicID("/uploads/../secret.txt"))
+}
+
+func TestLocalStoreDirectUpload(t *testing.T) {
+	t.Setenv("SIGNING_SECRET", "test-secret")
+	store, err := NewLocalStore(t.TempDir(), "/uploads")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	ticket, err := store.SignUpload(ctx, ProductImagesFolder, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "POST", ticket.Method)
+	token := strings.TrimPrefix(ticket.URL, "/api/uploads/")
+
+	_, err = store.Open(ctx, ticket.PublicID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+
+	publicID, err := store.VerifyUpload(token)
+	assert.NoError(t, err)
+	assert.Equal(t, ticket.PublicID, publicID)
+	assert.NoError(t, store.Receive(ctx, publicID, strings.NewReader("imagem")))
+	assert.ErrorIs(t, store.Receive(ctx, publicID, strings.NewReader("outra imagem")), ErrInvalidUploadURL, "an upload is never overwritten")
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(publicID)), "direct uploads are not served")
+
+	file, err := store.Open(ctx, publicID)
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	assert.Equal(t, "imagem", string(contents))
+
+	assert.NoError(t, store.Delete(ctx, publicID))
+	_, err = store.Open(ctx, publicID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+
+	expired, err := store.SignUpload(ctx, ProductImagesFolder, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	_, err = store.VerifyUpload(strings.TrimPrefix(expired.URL, "/api/uploads/"))
+	assert.ErrorIs(t, err, ErrInvalidUploadURL)
+
+	_, err = store.VerifyUpload(token + "x")
+	assert.ErrorIs(t, err, ErrInvalidUploadURL)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// ProductImagesFolder is the folder product images are uploaded to.
+const ProductImagesFolder = "sabordarondonia"
+
+const (
+	BackendCloudinary = "cloudinary"
+	BackendLocal      = "local"
+)
+
+var (
+	ErrInvalidPublicID = errors.New("invalid image public id")
+	ErrUnknownBackend  = errors.New("image storage backend is not configured")
+)
+
+// UploadResult identifies a stored image.
+type UploadResult struct {
+	PublicID string
+	URL      string
+}
+
+// ImageStore is where product images are kept. Images are addressed by a
+// public ID chosen by the store on upload.
+type ImageStore interface {
+	// Backend is the name of the backend, stored with every image so it is
+	// deleted from the right place.
+	Backend() string
+	// Upload stores the image read from file in folder.
+	Upload(ctx context.Context, file io.Reader, folder string) (*UploadResult, error)
+	// Delete removes an image. Deleting a missing image is not an error.
+	Delete(ctx context.Context, publicID string) error
+	// List returns every image stored in folder.
+	List(ctx context.Context, folder string) ([]Asset, error)
+	// URL returns the public URL of an image.
+	URL(publicID string) (string, error)
+	// PublicID returns the public ID of an image from its URL, or an empty
+	// string when the URL does not belong to this store.
+	PublicID(url string) string
+}
+
+// Asset is a file kept by a storage backend.
+type Asset struct {
+	PublicID  string
+	CreatedAt time.Time
+}
+
+// Images is the store used for product images, configured by Setup.
+var Images ImageStore
+
+// backends holds every configured store by name, so images uploaded before a
+// change of IMAGE_STORAGE can still be deleted.
+var backends = map[string]ImageStore{}
+
+// Register makes a store available to For.
+func Register(store ImageStore) {
+	backends[store.Backend()] = store
+}
+
+// For returns the store of a backend.
+func For(backend string) (ImageStore, error) {
+	if Images != nil && Images.Backend() == backend {
+		return Images, nil
+	}
+	if store, ok := backends[backend]; ok {
+		return store, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, backend)
+}
+
+// ForURL returns the store an image URL belongs to, along with the public ID
+// of the image, for images saved before their backend was recorded.
+func ForURL(url string) (ImageStore, string, error) {
+	if Images != nil {
+		if publicID := Images.PublicID(url); publicID != "" {
+			return Images, publicID, nil
+		}
+	}
+	for _, store := range Backends() {
+		if publicID := store.PublicID(url); publicID != "" {
+			return store, publicID, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%w: no store serves %q", ErrUnknownBackend, url)
+}
+
+// Backends returns every configured store, ordered by name.
+func Backends() []ImageStore {
+	stores := make([]ImageStore, 0, len(backends))
+	for _, store := range backends {
+		stores = append(stores, store)
+	}
+	sort.Slice(stores, func(i, j int) bool { return stores[i].Backend() < stores[j].Backend() })
+	return stores
+}
+
+// Setup configures Images from the environment. IMAGE_STORAGE selects the
+// backend: "cloudinary" uses CLOUDINARY_URL, "local" keeps the files in
+// IMAGE_STORAGE_DIR and serves them under IMAGE_BASE_URL. When it is unset,
+// Cloudinary is used if CLOUDINARY_URL is set. Cloudinary stays registered
+// for deletions whenever CLOUDINARY_URL is set, as does the local store.
+func Setup() {
+	backend := os.Getenv("IMAGE_STORAGE")
+	if backend == "" {
+		backend = BackendLocal
+		if os.Getenv("CLOUDINARY_URL") != "" {
+			backend = BackendCloudinary
+		}
+	}
+
+	var err error
+	switch backend {
+	case BackendCloudinary:
+		Images, err = NewCloudinaryStore(os.Getenv("CLOUDINARY_URL"))
+	case BackendLocal:
+		Images, err = NewLocalStore(LocalDir(), LocalBaseURL())
+	default:
+		log.Fatalf("Unknown IMAGE_STORAGE %q: expected cloudinary or local", backend)
+	}
+	if err != nil {
+		log.Fatalf("Failed to initialize %s image storage: %s", backend, err)
+	}
+	Register(Images)
+	log.Printf("Storing images in %s", backend)
+
+	if _, ok := backends[BackendCloudinary]; !ok && os.Getenv("CLOUDINARY_URL") != "" {
+		if store, err := NewCloudinaryStore(os.Getenv("CLOUDINARY_URL")); err == nil {
+			Register(store)
+		} else {
+			log.Printf("Cloudinary is not available for deletions: %s", err)
+		}
+	}
+	if _, ok := backends[BackendLocal]; !ok {
+		if store, err := NewLocalStore(LocalDir(), LocalBaseURL()); err == nil {
+			Register(store)
+		} else {
+			log.Printf("Local image storage is not available for deletions: %s", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	defer func(images ImageStore, registered map[string]ImageStore) {
+		Images, backends = images, registered
+	}(Images, backends)
+
+	local, err := NewLocalStore(t.TempDir(), "/uploads")
+	assert.NoError(t, err)
+	Images, backends = local, map[string]ImageStore{}
+
+	store, err := For(BackendLocal)
+	assert.NoError(t, err)
+	assert.Same(t, local, store)
+
+	_, err = For(BackendCloudinary)
+	assert.ErrorIs(t, err, ErrUnknownBackend)
+
+	cloudinary := &CloudinaryStore{}
+	Register(cloudinary)
+	store, err = For(BackendCloudinary)
+	assert.NoError(t, err)
+	assert.Same(t, cloudinary, store)
+	assert.Equal(t, []ImageStore{cloudinary}, Backends())
+
+	store, publicID, err := ForURL("/uploads/products/a.jpg")
+	assert.NoError(t, err)
+	assert.Same(t, local, store)
+	assert.Equal(t, "products/a.jpg", publicID)
+
+	store, publicID, err = ForURL("https://res.cloudinary.com/demo/image/upload/v1/products/b.jpg")
+	assert.NoError(t, err)
+	assert.Same(t, cloudinary, store)
+	assert.Equal(t, "products/b", publicID)
+
+	_, _, err = ForURL("https://example.com/products/c.jpg")
+	assert.ErrorIs(t, err, ErrUnknownBackend)
+}
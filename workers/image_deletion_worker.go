@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"products/cleanup"
+	"products/config"
+	"products/database"
+	"time"
+)
+
+const (
+	defaultImageDeletionInterval = 30 * time.Second
+	imageDeletionBatch           = 100
+)
+
+// StartImageDeletionWorker periodically deletes the queued image files from
+// their storage backends, until ctx is cancelled. The interval is configured
+// through IMAGE_DELETION_INTERVAL.
+func StartImageDeletionWorker(ctx context.Context) {
+	interval := config.Duration("IMAGE_DELETION_INTERVAL", defaultImageDeletionInterval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				result, err := cleanup.Process(ctx, database.DB, now, imageDeletionBatch)
+				if err != nil {
+					log.Printf("Error processing image deletions: %s", err)
+				}
+				if result.Deleted > 0 || result.Retried > 0 || result.Failed > 0 {
+					log.Printf("Image deletions: %d deleted, %d to retry, %d failed", result.Deleted, result.Retried, result.Failed)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,131 @@
+package workers
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"log"
+	"products/config"
+	"products/database"
+	"products/models"
+	"products/storage"
+	"time"
+)
+
+const (
+	defaultImageGCInterval = 24 * time.Hour
+	defaultImageGCGrace    = 24 * time.Hour
+)
+
+// ImageGCResult summarizes a pass of the image garbage collector.
+type ImageGCResult struct {
+	Scanned  int
+	Orphaned []string
+	Deleted  int
+}
+
+// StartImageGC periodically deletes images of the storage backends that no
+// product image references, until ctx is cancelled. Images younger than
+// IMAGE_GC_GRACE_PERIOD are kept, as they may belong to an upload whose
+// database changes are not committed yet. With IMAGE_GC_DRY_RUN set, orphaned
+// images are only logged. The interval is configured through
+// IMAGE_GC_INTERVAL.
+func StartImageGC(ctx context.Context) {
+	interval := config.Duration("IMAGE_GC_INTERVAL", defaultImageGCInterval)
+	grace := config.Duration("IMAGE_GC_GRACE_PERIOD", defaultImageGCGrace)
+	dryRun := config.Bool("IMAGE_GC_DRY_RUN", false)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, store := range storage.Backends() {
+					result, err := CollectOrphanedImages(ctx, database.DB, store, grace, dryRun, now)
+					if err != nil {
+						log.Printf("Error collecting orphaned images in %s: %s", store.Backend(), err)
+						continue
+					}
+					if dryRun && len(result.Orphaned) > 0 {
+						log.Printf("Found %d orphaned images in %s: %v", len(result.Orphaned), store.Backend(), result.Orphaned)
+					}
+					if result.Deleted > 0 {
+						log.Printf("Deleted %d orphaned images from %s", result.Deleted, store.Backend())
+					}
+				}
+			}
+		}
+	}()
+}
+
+// CollectOrphanedImages lists the product images folder of store and deletes
+// every image older than grace that is neither a product image, one of its
+// renditions nor a product image_url. When dryRun is set nothing is deleted.
+func CollectOrphanedImages(ctx context.Context, db *gorm.DB, store storage.ImageStore, grace time.Duration, dryRun bool, now time.Time) (ImageGCResult, error) {
+	var result ImageGCResult
+
+	// Listing before loading the references means an image saved in the
+	// meantime is seen as referenced.
+	assets, err := store.List(ctx, storage.ProductImagesFolder)
+	if err != nil {
+		return result, err
+	}
+	result.Scanned = len(assets)
+
+	referenced, err := referencedImages(db, store)
+	if err != nil {
+		return result, err
+	}
+
+	cutoff := now.Add(-grace)
+	for _, asset := range assets {
+		if referenced[asset.PublicID] || asset.CreatedAt.After(cutoff) {
+			continue
+		}
+		result.Orphaned = append(result.Orphaned, asset.PublicID)
+		if dryRun {
+			continue
+		}
+		if err := store.Delete(ctx, asset.PublicID); err != nil {
+			log.Printf("Failed to delete orphaned image with public_id %s: %v", asset.PublicID, err)
+			continue
+		}
+		result.Deleted++
+	}
+	return result, nil
+}
+
+// referencedImages returns the public IDs of store still in use. Images
+// without a recorded backend are assumed to belong to every store.
+func referencedImages(db *gorm.DB, store storage.ImageStore) (map[string]bool, error) {
+	referenced := map[string]bool{}
+
+	var images []models.ProductImage
+	err := db.Select("public_id", "renditions").
+		Where("storage_backend = ? OR storage_backend = ''", store.Backend()).
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		referenced[image.PublicID] = true
+		for _, rendition := range image.Renditions {
+			referenced[rendition.PublicID] = true
+		}
+	}
+
+	var urls []string
+	err = db.Model(&models.Product{}).Where("image_url IS NOT NULL").Pluck("image_url", &urls).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		if publicID := store.PublicID(url); publicID != "" {
+			referenced[publicID] = true
+		}
+	}
+	return referenced, nil
+}
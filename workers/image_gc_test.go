@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"products/models"
+	"products/storage"
+	"testing"
+	"time"
+)
+
+func TestCollectOrphanedImages(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Product{}, &models.ProductImage{}); err != nil {
+		t.Fatalf("failed to auto migrate products: %v", err)
+	}
+	store, err := storage.NewLocalStore(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatalf("failed to create image storage: %v", err)
+	}
+
+	now := time.Now()
+	upload := func(age time.Duration) string {
+		result, err := store.Upload(context.Background(), bytes.NewReader([]byte("\x89PNG\r\n\x1a\n")), storage.ProductImagesFolder)
+		assert.NoError(t, err)
+		file := filepath.Join(store.Dir, filepath.FromSlash(result.PublicID))
+		assert.NoError(t, os.Chtimes(file, now.Add(-age), now.Add(-age)))
+		return result.PublicID
+	}
+
+	original, thumbnail, legacy := upload(48*time.Hour), upload(48*time.Hour), upload(48*time.Hour)
+	orphaned, recent := upload(48*time.Hour), upload(time.Hour)
+
+	legacyURL, _ := store.URL(legacy)
+	product := models.Product{Name: "Pé-de-moleque", Price: 400, ImageURL: &legacyURL}
+	db.Create(&product)
+	db.Create(&models.ProductImage{
+		ProductID:      product.ID,
+		PublicID:       original,
+		StorageBackend: storage.BackendLocal,
+		Renditions:     map[string]models.Rendition{"thumbnail": {PublicID: thumbnail}},
+	})
+
+	result, err := CollectOrphanedImages(context.Background(), db, store, 24*time.Hour, true, now)
+	assert.NoError(t, err)
+	assert.Equal(t, ImageGCResult{Scanned: 5, Orphaned: []string{orphaned}}, result)
+	assert.FileExists(t, filepath.Join(store.Dir, filepath.FromSlash(orphaned)), "a dry run deletes nothing")
+
+	result, err = CollectOrphanedImages(context.Background(), db, store, 24*time.Hour, false, now)
+	assert.NoError(t, err)
+	assert.Equal(t, ImageGCResult{Scanned: 5, Orphaned: []string{orphaned}, Deleted: 1}, result)
+
+	for _, publicID := range []string{original, thumbnail, legacy, recent} {
+		assert.FileExists(t, filepath.Join(store.Dir, filepath.FromSlash(publicID)))
+	}
+	assert.NoFileExists(t, filepath.Join(store.Dir, filepath.FromSlash(orphaned)))
+}
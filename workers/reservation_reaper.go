@@ -0,0 +1,39 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"products/config"
+	"products/database"
+	"products/inventory"
+	"time"
+)
+
+const defaultReaperInterval = time.Minute
+
+// StartReservationReaper periodically expires reservations that were neither
+// confirmed nor released in time, until ctx is cancelled. The interval is
+// configured through RESERVATION_REAPER_INTERVAL.
+func StartReservationReaper(ctx context.Context) {
+	interval := config.Duration("RESERVATION_REAPER_INTERVAL", defaultReaperInterval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expired, err := inventory.ExpireReservations(database.DB, now)
+				if err != nil {
+					log.Printf("Error expiring reservations: %s", err)
+				}
+				if expired > 0 {
+					log.Printf("Expired %d reservations", expired)
+				}
+			}
+		}
+	}()
+}